@@ -2,15 +2,24 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/googollee/go-socket.io"
+	"github.com/m-o-s-e-s/mgm/core/host"
 	"github.com/m-o-s-e-s/mgm/core/logger"
+	"github.com/m-o-s-e-s/mgm/core/region"
 	"github.com/m-o-s-e-s/mgm/mgm"
 	"github.com/satori/go.uuid"
 )
 
+var errNoHost = errors.New("Region is not assigned to a host")
+
+// maxLogLines caps how much captured region output a client may fetch at once
+const maxLogLines = 5000
+
 type userResponse struct {
 	Success bool
 	Message string
@@ -50,12 +59,35 @@ func (m Manager) NewClient(so socketio.Socket, guid uuid.UUID) {
 		if !m.uMgr.UserIsAdmin(c.uid) {
 			return string(permissionDenied)
 		}
-		err := m.hMgr.AddHost(hostString)
+		secret, err := m.hMgr.AddHost(hostString)
 		if err != nil {
 			resp, _ := json.Marshal(userResponse{false, err.Error()})
 			return string(resp)
 		}
-		return string(success)
+		//the secret is only ever revealed here, it must be placed in the node configuration
+		resp, _ := json.Marshal(userResponse{true, secret})
+		return string(resp)
+	})
+
+	so.On("RotateHostSecret", func(idString string) string {
+		c.log.Info("Requesting rotate secret for host %v", idString)
+		// only admins may operate on hosts
+		if !m.uMgr.UserIsAdmin(c.uid) {
+			return string(permissionDenied)
+		}
+		//parse host id from string
+		id, err := strconv.ParseInt(idString, 10, 64)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		secret, err := m.hMgr.RotateHostSecret(id)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		resp, _ := json.Marshal(userResponse{true, secret})
+		return string(resp)
 	})
 
 	so.On("RemoveHost", func(idString string) string {
@@ -79,36 +111,526 @@ func (m Manager) NewClient(so socketio.Socket, guid uuid.UUID) {
 		return string(success)
 	})
 
+	so.On("DrainHost", func(idString string) string {
+		c.log.Info("Requesting drain host %v", idString)
+		// only admins may operate on hosts
+		if !m.uMgr.UserIsAdmin(c.uid) {
+			return string(permissionDenied)
+		}
+		//parse host id from string
+		id, err := strconv.ParseInt(idString, 10, 64)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		h, ok := m.hMgr.GetHost(id)
+		if !ok {
+			resp, _ := json.Marshal(userResponse{false, "Host does not exist"})
+			return string(resp)
+		}
+		u, _ := m.uMgr.GetUser(c.uid)
+		jobID := m.jMgr.CreateDrainHostJob(u, h)
+		if jobID == 0 {
+			resp, _ := json.Marshal(userResponse{false, "Could not create drain job"})
+			return string(resp)
+		}
+
+		//draining is long running, progress is reported through the job
+		go func() {
+			err := m.hMgr.DrainHost(id, u.Name, func(status string) {
+				m.jMgr.UpdateJobStatus(jobID, status)
+			})
+			if err != nil {
+				c.log.Error("Drain of host %v failed: %v", id, err.Error())
+				m.jMgr.UpdateJobStatus(jobID, fmt.Sprintf("Failed: %v", err.Error()))
+			}
+		}()
+
+		resp, _ := json.Marshal(userResponse{true, "Drain started"})
+		return string(resp)
+	})
+
 	so.On("StartRegion", func(msg string) string {
-		return string(permissionDenied)
+		c.log.Info("Requesting start region %v", msg)
+		// only admins may operate on regions
+		if !m.uMgr.UserIsAdmin(c.uid) {
+			return string(permissionDenied)
+		}
+		r, h, err := m.getRegionAndHost(msg)
+		unassigned := err == errNoHost
+		if err != nil && !unassigned {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		//the lifecycle rejects requests the region cannot act on, such as a start while stopping or starting,
+		//before the region is placed anywhere
+		u, _ := m.uMgr.GetUser(c.uid)
+		undo, err := m.rMgr.RequestTransition(r.UUID, mgm.RegionStarting, u.Name, "Start requested")
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		if unassigned {
+			//unassigned regions are placed by MGM
+			var p host.Placement
+			r, p, err = m.hMgr.AutoAssignRegion(r)
+			if err != nil {
+				undo(fmt.Sprintf("Placement failed: %v", err.Error()))
+				resp, _ := json.Marshal(userResponse{false, err.Error()})
+				return string(resp)
+			}
+			h = p.Host
+			c.log.Info("Region %v placed: %v", r.UUID, p.Reason)
+		}
+		err = m.hMgr.StartRegionOnHost(r, h)
+		if err != nil {
+			undo(fmt.Sprintf("Start failed: %v", err.Error()))
+			if unassigned {
+				//leave the region as it was found, rather than on a host it never ran on
+				m.hMgr.RemoveRegionFromHost(r, h)
+				m.hMgr.UnassignRegion(r.UUID)
+			}
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		return string(success)
 	})
 
 	so.On("StopRegion", func(msg string) string {
-		return string(permissionDenied)
+		c.log.Info("Requesting stop region %v", msg)
+		// only admins may operate on regions
+		if !m.uMgr.UserIsAdmin(c.uid) {
+			return string(permissionDenied)
+		}
+		r, h, err := m.getRegionAndHost(msg)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		//an optional in-world alert sent before the region quits
+		type alert struct {
+			Message string
+		}
+		a := alert{}
+		json.Unmarshal([]byte(msg), &a)
+		//the lifecycle rejects requests the region cannot act on, such as a stop while stopped
+		u, _ := m.uMgr.GetUser(c.uid)
+		undo, err := m.rMgr.RequestTransition(r.UUID, mgm.RegionStopping, u.Name, "Stop requested")
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		jobID := m.jMgr.CreateStopRegionJob(u, r)
+		if jobID == 0 {
+			undo("Could not create stop job")
+			resp, _ := json.Marshal(userResponse{false, "Could not create stop job"})
+			return string(resp)
+		}
+
+		//stopping escalates over the grace period, progress is reported through the job
+		go func() {
+			err := m.hMgr.StopRegionOnHost(r, h, a.Message, func(status string) {
+				m.jMgr.UpdateJobStatus(jobID, status)
+			})
+			if err != nil {
+				c.log.Error("Stop of region %v failed: %v", r.UUID, err.Error())
+				undo(fmt.Sprintf("Stop failed: %v", err.Error()))
+				m.jMgr.UpdateJobStatus(jobID, fmt.Sprintf("Failed: %v", err.Error()))
+				return
+			}
+			m.jMgr.UpdateJobStatus(jobID, "Stopped")
+		}()
+
+		resp, _ := json.Marshal(userResponse{true, "Stop started"})
+		return string(resp)
 	})
 
 	so.On("KillRegion", func(msg string) string {
-		return string(permissionDenied)
+		c.log.Info("Requesting kill region %v", msg)
+		// only admins may operate on regions
+		if !m.uMgr.UserIsAdmin(c.uid) {
+			return string(permissionDenied)
+		}
+		r, h, err := m.getRegionAndHost(msg)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		//the lifecycle rejects requests the region cannot act on, such as a start while stopping
+		u, _ := m.uMgr.GetUser(c.uid)
+		undo, err := m.rMgr.RequestTransition(r.UUID, mgm.RegionStopping, u.Name, "Kill requested")
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		err = m.hMgr.KillRegionOnHost(r, h)
+		if err != nil {
+			undo(fmt.Sprintf("Kill failed: %v", err.Error()))
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		return string(success)
+	})
+
+	so.On("GetRegionLog", func(msg string) string {
+		c.log.Info("Requesting region log %v", msg)
+		// region output may contain credentials, admins only
+		if !m.uMgr.UserIsAdmin(c.uid) {
+			return string(permissionDenied)
+		}
+		type response struct {
+			Success bool
+			Message string
+			Lines   []string
+		}
+		r, h, err := m.getRegionAndHost(msg)
+		if err != nil {
+			resp, _ := json.Marshal(response{Message: err.Error()})
+			return string(resp)
+		}
+		type logRequest struct {
+			Lines int
+		}
+		req := logRequest{}
+		json.Unmarshal([]byte(msg), &req)
+		if req.Lines > maxLogLines {
+			req.Lines = maxLogLines
+		}
+		lines, err := m.hMgr.GetRegionLog(r, h, req.Lines)
+		if err != nil {
+			resp, _ := json.Marshal(response{Message: err.Error()})
+			return string(resp)
+		}
+		resp, _ := json.Marshal(response{Success: true, Lines: lines})
+		return string(resp)
 	})
 
 	so.On("OpenConsole", func(msg string) string {
-		return string(permissionDenied)
+		c.log.Info("Requesting open console %v", msg)
+		r, h, err := m.getRegionAndHost(msg)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		//the console policy decides who may open it, and which commands they may run
+		u, ok := m.uMgr.GetUser(c.uid)
+		if !ok {
+			return string(permissionDenied)
+		}
+		//scrollback and live output arrive as RegionConsole events, in order from the hub's queue
+		err = m.consoles.Subscribe(r, h, so.Id(), m.consoleUser(u, r), func(rc mgm.RegionConsole) {
+			so.Emit("RegionConsole", string(rc.Serialize()))
+		})
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		return string(success)
 	})
 
 	so.On("ConsoleCommand", func(msg string) string {
-		return string(permissionDenied)
+		c.log.Info("Requesting console command %v", msg)
+		type consoleCommand struct {
+			RegionUUID uuid.UUID
+			Command    string
+		}
+		req := consoleCommand{}
+		err := json.Unmarshal([]byte(msg), &req)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, "Invalid data packet"})
+			return string(resp)
+		}
+		err = m.consoles.Write(req.RegionUUID, so.Id(), req.Command)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		return string(success)
 	})
 
 	so.On("CloseConsole", func(msg string) string {
-		return string(permissionDenied)
+		c.log.Info("Requesting close console %v", msg)
+		type consoleRequest struct {
+			RegionUUID uuid.UUID
+		}
+		req := consoleRequest{}
+		err := json.Unmarshal([]byte(msg), &req)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, "Invalid data packet"})
+			return string(resp)
+		}
+		m.consoles.Unsubscribe(req.RegionUUID, so.Id())
+		return string(success)
+	})
+
+	so.On("disconnection", func() {
+		//release any consoles this socket held open
+		m.consoles.UnsubscribeAll(so.Id())
+	})
+
+	so.On("GetUptime", func(msg string) string {
+		c.log.Info("Requesting uptime %v", msg)
+		if !m.uMgr.UserIsAdmin(c.uid) {
+			return string(permissionDenied)
+		}
+		type uptimeRequest struct {
+			Subject string
+			ID      string
+			From    time.Time
+			Until   time.Time
+		}
+		type response struct {
+			Success bool
+			Message string
+			Report  mgm.UptimeReport
+		}
+		req := uptimeRequest{}
+		err := json.Unmarshal([]byte(msg), &req)
+		if err != nil {
+			resp, _ := json.Marshal(response{Message: "Invalid data packet"})
+			return string(resp)
+		}
+		//default to the last thirty days
+		if req.Until.IsZero() {
+			req.Until = time.Now()
+		}
+		if req.From.IsZero() {
+			req.From = req.Until.Add(-30 * 24 * time.Hour)
+		}
+		if !req.From.Before(req.Until) {
+			resp, _ := json.Marshal(response{Message: "From must be before Until"})
+			return string(resp)
+		}
+		var report mgm.UptimeReport
+		switch req.Subject {
+		case "host":
+			id, perr := strconv.ParseInt(req.ID, 10, 64)
+			if perr != nil {
+				resp, _ := json.Marshal(response{Message: "Invalid host id"})
+				return string(resp)
+			}
+			report, err = m.hMgr.GetHostUptime(id, req.From, req.Until)
+		case "region":
+			id, perr := uuid.FromString(req.ID)
+			if perr != nil {
+				resp, _ := json.Marshal(response{Message: "Invalid region id"})
+				return string(resp)
+			}
+			report, err = m.rMgr.GetRegionUptime(id, req.From, req.Until)
+		default:
+			resp, _ := json.Marshal(response{Message: fmt.Sprintf("Unknown subject %v", req.Subject)})
+			return string(resp)
+		}
+		if err != nil {
+			resp, _ := json.Marshal(response{Message: err.Error()})
+			return string(resp)
+		}
+		resp, _ := json.Marshal(response{Success: true, Report: report})
+		return string(resp)
+	})
+
+	so.On("CreateRegion", func(msg string) string {
+		c.log.Info("Requesting create region %v", msg)
+		// only admins may manage regions
+		if !m.uMgr.UserIsAdmin(c.uid) {
+			return string(permissionDenied)
+		}
+		type regionRequest struct {
+			Name string
+			Size uint
+			LocX uint
+			LocY uint
+		}
+		type response struct {
+			Success bool
+			Message string
+			Region  json.RawMessage
+		}
+		req := regionRequest{Size: 1}
+		err := json.Unmarshal([]byte(msg), &req)
+		if err != nil {
+			resp, _ := json.Marshal(response{Message: "Invalid data packet"})
+			return string(resp)
+		}
+		r, err := m.rMgr.CreateRegion(req.Name, req.Size, req.LocX, req.LocY)
+		if err != nil {
+			resp, _ := json.Marshal(response{Message: err.Error()})
+			return string(resp)
+		}
+		//new regions are placed straight away, the slot is reserved under the host lock by AssignRegion
+		message := ""
+		placed, p, err := m.hMgr.AutoAssignRegion(r)
+		if err != nil {
+			//the region exists regardless, it is placed again when first started
+			c.log.Info("Region %v created without a host: %v", r.UUID, err.Error())
+			message = fmt.Sprintf("Region created, but not placed on a host: %v", err.Error())
+		} else {
+			r = placed
+			c.log.Info("Region %v placed: %v", r.UUID, p.Reason)
+		}
+		//Serialize strips console credentials from the record
+		resp, _ := json.Marshal(response{Success: true, Message: message, Region: r.Serialize()})
+		return string(resp)
+	})
+
+	so.On("UpdateRegion", func(msg string) string {
+		c.log.Info("Requesting update region %v", msg)
+		// only admins may manage regions
+		if !m.uMgr.UserIsAdmin(c.uid) {
+			return string(permissionDenied)
+		}
+		r, _, err := m.getRegionAndHost(msg)
+		if err != nil && err != errNoHost {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		//fields left out of the request keep their current values
+		type regionRequest struct {
+			Name string
+			Size uint
+			LocX uint
+			LocY uint
+		}
+		req := regionRequest{r.Name, r.Size, r.LocX, r.LocY}
+		json.Unmarshal([]byte(msg), &req)
+		_, err = m.rMgr.ModifyRegion(r.UUID, req.Name, req.Size, req.LocX, req.LocY)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		return string(success)
 	})
 
 	so.On("SetLocation", func(msg string) string {
-		return string(permissionDenied)
+		c.log.Info("Requesting set location %v", msg)
+		// only admins may manage regions
+		if !m.uMgr.UserIsAdmin(c.uid) {
+			return string(permissionDenied)
+		}
+		r, _, err := m.getRegionAndHost(msg)
+		if err != nil && err != errNoHost {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		type location struct {
+			LocX uint
+			LocY uint
+		}
+		loc := location{}
+		err = json.Unmarshal([]byte(msg), &loc)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, "Invalid data packet"})
+			return string(resp)
+		}
+		_, err = m.rMgr.ModifyRegion(r.UUID, r.Name, r.Size, loc.LocX, loc.LocY)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		return string(success)
+	})
+
+	so.On("DeleteRegion", func(msg string) string {
+		c.log.Info("Requesting delete region %v", msg)
+		// only admins may manage regions
+		if !m.uMgr.UserIsAdmin(c.uid) {
+			return string(permissionDenied)
+		}
+		r, _, err := m.getRegionAndHost(msg)
+		if err != nil && err != errNoHost {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		err = m.hMgr.DeleteRegion(r)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		return string(success)
+	})
+
+	so.On("SetVersion", func(msg string) string {
+		c.log.Info("Requesting set version %v", msg)
+		// only admins may choose which opensim build a region runs
+		if !m.uMgr.UserIsAdmin(c.uid) {
+			return string(permissionDenied)
+		}
+		type versionRequest struct {
+			RegionUUID uuid.UUID
+			Version    string
+		}
+		req := versionRequest{}
+		err := json.Unmarshal([]byte(msg), &req)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, "Invalid data packet"})
+			return string(resp)
+		}
+		r, ok := m.rMgr.GetRegion(req.RegionUUID)
+		if !ok {
+			resp, _ := json.Marshal(userResponse{false, "Region does not exist"})
+			return string(resp)
+		}
+		u, _ := m.uMgr.GetUser(c.uid)
+		_, err = m.hMgr.SetRegionVersion(r, req.Version, u.Name)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, err.Error()})
+			return string(resp)
+		}
+		return string(success)
 	})
 
 	so.On("SetHost", func(msg string) string {
-		return string(permissionDenied)
+		c.log.Info("Requesting set host %v", msg)
+		// only admins may move regions between hosts
+		if !m.uMgr.UserIsAdmin(c.uid) {
+			return string(permissionDenied)
+		}
+		//Force moves a region off of an offline host, which may still be running it
+		type setHost struct {
+			RegionUUID uuid.UUID
+			ID         int64
+			Force      bool
+		}
+		req := setHost{}
+		err := json.Unmarshal([]byte(msg), &req)
+		if err != nil {
+			resp, _ := json.Marshal(userResponse{false, "Invalid data packet"})
+			return string(resp)
+		}
+		r, ok := m.rMgr.GetRegion(req.RegionUUID)
+		if !ok {
+			resp, _ := json.Marshal(userResponse{false, "Region does not exist"})
+			return string(resp)
+		}
+		h, ok := m.hMgr.GetHost(req.ID)
+		if !ok {
+			resp, _ := json.Marshal(userResponse{false, "Host does not exist"})
+			return string(resp)
+		}
+		if r.Host == h.ID {
+			resp, _ := json.Marshal(userResponse{false, "Region is already on that host"})
+			return string(resp)
+		}
+		u, _ := m.uMgr.GetUser(c.uid)
+		jobID := m.jMgr.CreateMigrateRegionJob(u, r, h)
+		if jobID == 0 {
+			resp, _ := json.Marshal(userResponse{false, "Could not create migration job"})
+			return string(resp)
+		}
+
+		//migration is long running, progress is reported through the job
+		go func() {
+			err := m.hMgr.MigrateRegion(r, h, u.Name, req.Force, func(status string) {
+				m.jMgr.UpdateJobStatus(jobID, status)
+			})
+			if err != nil {
+				c.log.Error("Migration of region %v failed: %v", r.UUID, err.Error())
+				m.jMgr.UpdateJobStatus(jobID, fmt.Sprintf("Failed: %v", err.Error()))
+			}
+		}()
+
+		resp, _ := json.Marshal(userResponse{true, "Migration started"})
+		return string(resp)
 	})
 
 	so.On("SetEstate", func(msg string) string {
@@ -185,6 +707,70 @@ func (m Manager) NewClient(so socketio.Socket, guid uuid.UUID) {
 		return string(result)
 	})
 
+	so.On("GetMetrics", func(msg string) string {
+		c.log.Info("Requesting metrics %v", msg)
+		if !m.uMgr.UserIsAdmin(c.uid) {
+			return string(permissionDenied)
+		}
+		type metricsRequest struct {
+			Subject string
+			ID      string
+			Range   string
+		}
+		type response struct {
+			Success bool
+			Message string
+			Points  []mgm.MetricPoint
+		}
+		req := metricsRequest{}
+		err := json.Unmarshal([]byte(msg), &req)
+		if err != nil {
+			resp, _ := json.Marshal(response{Message: "Invalid data packet"})
+			return string(resp)
+		}
+		//pick the rollup that gives a plottable number of points for the range
+		var resolution, span time.Duration
+		switch req.Range {
+		case "", "day":
+			resolution, span = time.Minute, 24*time.Hour
+		case "week":
+			resolution, span = time.Hour, 7*24*time.Hour
+		case "month":
+			resolution, span = time.Hour, 30*24*time.Hour
+		case "year":
+			resolution, span = 24*time.Hour, 365*24*time.Hour
+		default:
+			resp, _ := json.Marshal(response{Message: fmt.Sprintf("Unknown range %v", req.Range)})
+			return string(resp)
+		}
+		var points []mgm.MetricPoint
+		since := time.Now().Add(-span)
+		switch req.Subject {
+		case "host":
+			id, perr := strconv.ParseInt(req.ID, 10, 64)
+			if perr != nil {
+				resp, _ := json.Marshal(response{Message: "Invalid host id"})
+				return string(resp)
+			}
+			points, err = m.hMgr.GetHostMetrics(id, resolution, since)
+		case "region":
+			id, perr := uuid.FromString(req.ID)
+			if perr != nil {
+				resp, _ := json.Marshal(response{Message: "Invalid region id"})
+				return string(resp)
+			}
+			points, err = m.rMgr.GetRegionMetrics(id, resolution, since)
+		default:
+			err = fmt.Errorf("Unknown subject %v", req.Subject)
+		}
+		if err != nil {
+			resp, _ := json.Marshal(response{Message: err.Error()})
+			return string(resp)
+		}
+		resp, _ := json.Marshal(response{Success: true, Points: points})
+		return string(resp)
+	})
+
 	so.On("GetState", func(msg string) string {
 		c.log.Info("Requesting MGM State")
 
@@ -222,3 +808,50 @@ func (m Manager) NewClient(so socketio.Socket, guid uuid.UUID) {
 		return string(result)
 	})
 }
+
+// consoleUser describes a user to the console policy, with the roles they hold over a region
+func (m Manager) consoleUser(u mgm.User, r mgm.Region) region.ConsoleUser {
+	cu := region.ConsoleUser{ID: u.UserID, Name: u.Name, AccessLevel: u.AccessLevel}
+	if m.uMgr.UserIsAdmin(u.UserID) {
+		cu.Roles = append(cu.Roles, region.RoleAdmin)
+	}
+	for _, e := range m.uMgr.GetEstates() {
+		inEstate := false
+		for _, id := range e.Regions {
+			inEstate = inEstate || id == r.UUID
+		}
+		if !inEstate {
+			continue
+		}
+		if e.Owner == u.UserID {
+			cu.Roles = append(cu.Roles, region.RoleEstateOwner)
+		}
+		for _, id := range e.Managers {
+			if id == u.UserID {
+				cu.Roles = append(cu.Roles, region.RoleEstateManager)
+			}
+		}
+	}
+	return cu
+}
+
+// getRegionAndHost resolves a {RegionUUID: uuid.UUID} request into the region and its host
+func (m Manager) getRegionAndHost(msg string) (mgm.Region, mgm.Host, error) {
+	type regionRequest struct {
+		RegionUUID uuid.UUID
+	}
+	req := regionRequest{}
+	err := json.Unmarshal([]byte(msg), &req)
+	if err != nil {
+		return mgm.Region{}, mgm.Host{}, errors.New("Invalid data packet")
+	}
+	r, ok := m.rMgr.GetRegion(req.RegionUUID)
+	if !ok {
+		return mgm.Region{}, mgm.Host{}, errors.New("Region does not exist")
+	}
+	h, ok := m.hMgr.GetHost(r.Host)
+	if !ok {
+		return r, mgm.Host{}, errNoHost
+	}
+	return r, h, nil
+}
@@ -12,13 +12,14 @@ import (
 )
 
 // NewManager constructs a session manager for use
-func NewManager(uMgr user.Manager, hMgr host.Manager, rMgr region.Manager, jMgr job.Manager, notify Notifier, log logger.Log) Manager {
+func NewManager(uMgr user.Manager, hMgr host.Manager, rMgr region.Manager, jMgr job.Manager, consoles region.ConsoleHub, notify Notifier, log logger.Log) Manager {
 	m := Manager{}
 	m.log = logger.Wrap("CLIENT", log)
 	m.uMgr = uMgr
 	m.hMgr = hMgr
 	m.rMgr = rMgr
 	m.jMgr = jMgr
+	m.consoles = consoles
 
 	m.clients = make(map[uuid.UUID]userConn)
 	m.clientMutex = &sync.Mutex{}
@@ -34,6 +35,7 @@ type Manager struct {
 	hMgr        host.Manager
 	rMgr        region.Manager
 	jMgr        job.Manager
+	consoles    region.ConsoleHub
 	clients     map[uuid.UUID]userConn
 	clientMutex *sync.Mutex
 	log         logger.Log
@@ -48,6 +50,16 @@ func listen(m *Manager, n Notifier) {
 			m.HostAdded(h)
 		case hs := <-n.hStat:
 			m.HostStat(hs)
+		case j := <-n.jUp:
+			m.JobUpdated(j)
+		case r := <-n.rUp:
+			m.RegionUpdated(r)
+		case r := <-n.rDel:
+			m.RegionDeleted(r)
+		case rs := <-n.rStat:
+			m.RegionStat(rs)
+		case re := <-n.rEvt:
+			m.RegionEvent(re)
 		}
 	}
 }
@@ -0,0 +1,15 @@
+package client
+
+import "github.com/m-o-s-e-s/mgm/mgm"
+
+// JobUpdated notifies the owner of a job that it has been created or modified
+func (m Manager) JobUpdated(j mgm.Job) {
+	m.clientMutex.Lock()
+	defer m.clientMutex.Unlock()
+
+	if c, ok := m.clients[j.User]; ok {
+		go func(conn userConn, job mgm.Job) {
+			conn.sio.Emit("Job", string(job.Serialize()))
+		}(c, j)
+	}
+}
@@ -10,6 +10,7 @@ type Notifier struct {
 	rUp   chan mgm.Region
 	rDel  chan mgm.Region
 	rStat chan mgm.RegionStat
+	rEvt  chan mgm.RegionEvent
 	eUp   chan mgm.Estate
 	eDel  chan mgm.Estate
 	jUp   chan mgm.Job
@@ -25,6 +26,7 @@ func NewNotifier() Notifier {
 		rUp:   make(chan mgm.Region, 32),
 		rStat: make(chan mgm.RegionStat, 32),
 		rDel:  make(chan mgm.Region, 32),
+		rEvt:  make(chan mgm.RegionEvent, 32),
 		eUp:   make(chan mgm.Estate, 32),
 		eDel:  make(chan mgm.Estate, 32),
 		jUp:   make(chan mgm.Job, 32),
@@ -62,6 +64,11 @@ func (n Notifier) RegionStat(s mgm.RegionStat) {
 	n.rStat <- s
 }
 
+//RegionEvent notifies that a region process has changed state, such as crashing
+func (n Notifier) RegionEvent(e mgm.RegionEvent) {
+	n.rEvt <- e
+}
+
 //EstateUpdated notifies that an estate has been modified
 func (n Notifier) EstateUpdated(e mgm.Estate) {
 	n.eUp <- e
@@ -0,0 +1,61 @@
+package client
+
+import "github.com/m-o-s-e-s/mgm/mgm"
+
+// RegionStat notifies connected clients that a region status has updated
+func (m Manager) RegionStat(rs mgm.RegionStat) {
+	m.clientMutex.Lock()
+	defer m.clientMutex.Unlock()
+
+	for _, c := range m.clients {
+		go func(conn userConn, stat mgm.RegionStat) {
+			conn.sio.Emit("RegionStat", stat)
+		}(c, rs)
+	}
+}
+
+// RegionUpdated notifies connected clients that a region has been added or modified
+func (m Manager) RegionUpdated(r mgm.Region) {
+	m.clientMutex.Lock()
+	defer m.clientMutex.Unlock()
+
+	for _, c := range m.clients {
+		go func(conn userConn, region mgm.Region) {
+			//Serialize strips console credentials from the record
+			conn.sio.Emit("Region", string(region.Serialize()))
+		}(c, r)
+	}
+}
+
+// RegionDeleted notifies connected clients that a region has been removed
+func (m Manager) RegionDeleted(r mgm.Region) {
+	m.clientMutex.Lock()
+	defer m.clientMutex.Unlock()
+
+	for _, c := range m.clients {
+		go func(conn userConn, deleted mgm.RegionDeleted) {
+			conn.sio.Emit("RegionDeleted", string(deleted.Serialize()))
+		}(c, mgm.RegionDeleted{UUID: r.UUID})
+	}
+}
+
+// RegionEvent notifies connected clients of a region process event, alerting admins when a region is left down
+func (m Manager) RegionEvent(re mgm.RegionEvent) {
+	m.clientMutex.Lock()
+	defer m.clientMutex.Unlock()
+
+	for _, c := range m.clients {
+		go func(conn userConn, event mgm.RegionEvent) {
+			admin := m.uMgr.UserIsAdmin(conn.uid)
+			//region output may expose configuration and credentials, only admins receive it
+			if !admin {
+				event.Output = nil
+			}
+			conn.sio.Emit("RegionEvent", string(event.Serialize()))
+			down := event.Type == "CrashLoop" || event.Type == "StartupTimeout"
+			if down && admin {
+				conn.sio.Emit("Alert", event.Message)
+			}
+		}(c, re)
+	}
+}
@@ -15,6 +15,9 @@ type MgmConfig struct {
 		WebPort       int
 		NodePort      int
 		HubRegionUUID uuid.UUID
+
+		MissedHostStats int
+		PlacementPolicy string
 	}
 
 	Web struct {
@@ -39,4 +42,12 @@ type MgmConfig struct {
 	}
 
 	Email email.EmailConfig
+
+	// ConsolePolicy holds named console command rules, granted by role or minimum access level
+	ConsolePolicy map[string]*struct {
+		Role        []string
+		AccessLevel uint8
+		Allow       []string
+		Deny        []string
+	}
 }
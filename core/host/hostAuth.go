@@ -0,0 +1,68 @@
+package host
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-o-s-e-s/mgm/mgm"
+)
+
+// time allowed for a node to answer the enrollment challenge
+var authTimeout = 10 * time.Second
+
+// SignChallenge computes the enrollment response for a challenge using a host secret
+func SignChallenge(secret string, challenge string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(challenge))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// authenticate challenges a connecting node to prove it holds the secret for host h
+func authenticate(conn *websocket.Conn, h mgm.Host) error {
+	if h.Secret == "" {
+		return errors.New("Host has no enrollment secret, issue one with New Secret before the node can connect")
+	}
+
+	challenge, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(authTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	err = conn.WriteJSON(Message{MessageType: "AuthChallenge", Message: challenge})
+	if err != nil {
+		return err
+	}
+
+	msg := Message{}
+	err = conn.ReadJSON(&msg)
+	if err != nil {
+		return err
+	}
+	if msg.MessageType != "AuthResponse" {
+		return errors.New("Expected AuthResponse, received " + msg.MessageType)
+	}
+
+	expected := SignChallenge(h.Secret, challenge)
+	if !hmac.Equal([]byte(msg.Message), []byte(expected)) {
+		return errors.New("Invalid challenge response")
+	}
+
+	return conn.WriteJSON(Message{MessageType: "AuthAccepted"})
+}
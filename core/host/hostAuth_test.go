@@ -0,0 +1,111 @@
+package host
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-o-s-e-s/mgm/mgm"
+)
+
+// enroll runs authenticate for h against a node answering the challenge with respond, which may
+// send nothing by returning an empty message.  It returns the result of authenticate.
+func enroll(t *testing.T, h mgm.Host, respond func(challenge string) Message) error {
+	result := make(chan error, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			result <- err
+			return
+		}
+		defer conn.Close()
+		result <- authenticate(conn, h)
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := Message{}
+	if h.Secret != "" {
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.MessageType != "AuthChallenge" || msg.Message == "" {
+			t.Fatalf("got %v %q, want a challenge", msg.MessageType, msg.Message)
+		}
+		if resp := respond(msg.Message); resp.MessageType != "" {
+			conn.WriteJSON(resp)
+		}
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("authenticate did not return")
+		return nil
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	defer func(d time.Duration) { authTimeout = d }(authTimeout)
+	authTimeout = 100 * time.Millisecond
+
+	h := mgm.Host{ID: 1, Secret: "secret"}
+	answer := func(secret string) func(string) Message {
+		return func(challenge string) Message {
+			return Message{MessageType: "AuthResponse", Message: SignChallenge(secret, challenge)}
+		}
+	}
+
+	//a response captured from an earlier enrollment
+	var captured string
+	enroll(t, h, func(challenge string) Message {
+		captured = SignChallenge(h.Secret, challenge)
+		return Message{MessageType: "AuthResponse", Message: captured}
+	})
+
+	tests := []struct {
+		name    string
+		h       mgm.Host
+		respond func(string) Message
+		ok      bool
+	}{
+		{"correct signature", h, answer(h.Secret), true},
+		{"wrong secret", h, answer("guess"), false},
+		{"replayed response", h, func(string) Message { return Message{MessageType: "AuthResponse", Message: captured} }, false},
+		{"stale challenge", h, func(string) Message {
+			return Message{MessageType: "AuthResponse", Message: SignChallenge(h.Secret, "an earlier challenge")}
+		}, false},
+		{"unsigned response", h, func(string) Message { return Message{MessageType: "AuthResponse"} }, false},
+		{"wrong message", h, func(c string) Message { return Message{MessageType: "Register", Message: SignChallenge(h.Secret, c)} }, false},
+		{"no answer before the deadline", h, func(string) Message { return Message{} }, false},
+		{"host without a secret", mgm.Host{ID: 2}, answer(""), false},
+	}
+	for _, tt := range tests {
+		err := enroll(t, tt.h, tt.respond)
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: got %v, want accepted %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestSignChallenge(t *testing.T) {
+	if SignChallenge("secret", "challenge") != SignChallenge("secret", "challenge") {
+		t.Error("signatures of the same challenge differ")
+	}
+	if SignChallenge("secret", "challenge") == SignChallenge("other", "challenge") {
+		t.Error("signatures with different secrets match")
+	}
+	if SignChallenge("secret", "challenge") == SignChallenge("secret", "other") {
+		t.Error("signatures of different challenges match")
+	}
+}
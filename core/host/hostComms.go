@@ -1,7 +1,10 @@
 package host
 
 import (
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/m-o-s-e-s/mgm/core/logger"
@@ -15,26 +18,71 @@ type Comms struct {
 	Log        logger.Log
 }
 
+// ReadConnection reads messages off of the websocket until it fails, signalling Closing when it does
+func (c Comms) ReadConnection(in chan<- Message) {
+	for {
+		msg := Message{}
+		err := c.Connection.ReadJSON(&msg)
+		if err != nil {
+			c.Log.Error("Error reading from connection: %v", err.Error())
+			c.Closing <- true
+			return
+		}
+		in <- msg
+	}
+}
+
+// WriteConnection writes messages onto the websocket until the channel is closed
+func (c Comms) WriteConnection(out <-chan Message) {
+	for msg := range out {
+		err := c.Connection.WriteJSON(msg)
+		if err != nil {
+			//a failed write will surface as a failed read, and close the session
+			c.Log.Error("Error writing to connection: %v", err.Error())
+		}
+	}
+}
+
 // Message is a messagestructure for MGM<->node messages
 type Message struct {
 	ID          uint
 	MessageType string
 	response    chan<- error
+	report      func(string)
+	reply       func(Message)
 	Region      mgm.Region         `json:",omitempty"`
 	Message     string             `json:",omitempty"`
 	Register    Registration       `json:",omitempty"`
 	HStats      mgm.HostStat       `json:",omitempty"`
 	RStats      mgm.RegionStat     `json:",omitempty"`
 	Configs     []mgm.ConfigOption `json:",omitempty"`
+	Inventory   []mgm.RegionStat   `json:",omitempty"`
+	Event       mgm.RegionEvent    `json:",omitempty"`
+	Restart     mgm.RestartPolicy  `json:",omitempty"`
+	Runtime     mgm.Runtime        `json:",omitempty"`
+	Limits      mgm.ResourceLimits `json:",omitempty"`
+	Startup     time.Duration      `json:",omitempty"`
+	Lines       int                `json:",omitempty"`
+	Output      []string           `json:",omitempty"`
+	Console     mgm.ConsoleCall    `json:",omitempty"`
 	Host        mgm.Host           `json:"-"`
 	Estate      mgm.Estate         `json:"-"`
 }
 
 // Registration holds mgmNode information for MGM
 type Registration struct {
-	ExternalAddress string
-	Name            string
-	Slots           int
+	ExternalAddress    string
+	Name               string
+	Slots              int
+	MinRegionPort      int
+	MaxRegionPort      int
+	MinConsolePort     int
+	MaxConsolePort     int
+	Labels             []string
+	Builds             []string
+	ProtocolVersion    int
+	MinProtocolVersion int
+	Capabilities       []string
 }
 
 var wsupgrader = websocket.Upgrader{
@@ -50,41 +98,51 @@ func (m Manager) WShandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go process(&m, conn)
-}
+	//nodes are identified by the address they connect from
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		m.log.Error("Invalid remote address %v: %v", r.RemoteAddr, err.Error())
+		conn.Close()
+		return
+	}
 
-func process(m *Manager, c *websocket.Conn) {
-	log := logger.Wrap("comms", m.log)
-	//signal channel
-	ch := make(chan bool)
-	in := make(chan Message, 32)
-	//read on the socket
-	go func() {
-		for {
-			msg := Message{}
-			err := c.ReadJSON(&msg)
-			if err != nil {
-				log.Error(err.Error())
-				return
-			}
-			in <- msg
-		}
-	}()
-	//do not write on the socket, that happens elsewhere
+	h, ok := m.getHostByAddress(address)
+	if !ok {
+		m.log.Info("Connection from unregistered address %v rejected", address)
+		conn.Close()
+		return
+	}
 
-	//process packets
-	for {
-		select {
-		case <-ch:
-			log.Info("Processing loop exiting")
-			return
-		case msg := <-in:
-			switch msg.MessageType {
-			case "HostStats":
-				m.UpdateHostStats(msg.HStats)
-			default:
-				log.Error("Unexpected message type %v:", msg.MessageType)
-			}
-		}
+	err = authenticate(conn, h)
+	if err != nil {
+		m.log.Error("Host %v at %v failed enrollment: %v", h.ID, address, err.Error())
+		conn.Close()
+		return
+	}
+
+	m.hcMutex.Lock()
+	if _, ok := m.hostConnections[h.ID]; ok {
+		m.hcMutex.Unlock()
+		m.log.Info("Host %v is already connected, rejecting connection from %v", h.ID, address)
+		conn.Close()
+		return
 	}
+
+	hs := hostSession{
+		host:    h,
+		conn:    conn,
+		cmdMsgs: make(chan Message, 32),
+		done:    make(chan bool),
+		log:     logger.Wrap(strconv.FormatInt(h.ID, 10), m.log),
+		rMgr:    m.rMgr,
+	}
+	m.hostConnections[h.ID] = hs
+	m.hcMutex.Unlock()
+	//the heartbeat starts at connection, a node that never reports will time out
+	m.hsMutex.Lock()
+	m.hostSeen[h.ID] = time.Now()
+	m.hsMutex.Unlock()
+	m.log.Info("Host %v connected from %v", h.ID, address)
+
+	go hs.process(m.closing, m.register, m.hStatChan, m.rStatChan)
 }
@@ -2,8 +2,11 @@ package host
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/m-o-s-e-s/mgm/core/logger"
 	"github.com/m-o-s-e-s/mgm/core/persist"
@@ -17,21 +20,30 @@ type notifier interface {
 	HostStat(mgm.HostStat)
 }
 
-type hostConn struct {
-}
-
-func (hc hostConn) Close() {
+// HostStatInterval is how often nodes report HostStats, each report is a heartbeat
+const HostStatInterval = time.Second
 
-}
+// defaultMissedHostStats is used when no limit on missed heartbeats is configured
+const defaultMissedHostStats = 30
 
 // NewManager constructs NodeManager instances
-func NewManager(port int, rMgr region.Manager, pers persist.MGMDB, notify notifier, log logger.Log) Manager {
+func NewManager(port int, missedHostStats int, policy PlacementPolicy, rMgr region.Manager, pers persist.MGMDB, notify notifier, log logger.Log) Manager {
 	mgr := Manager{}
 	mgr.listenPort = port
+	mgr.policy = policy
+	if missedHostStats <= 0 {
+		missedHostStats = defaultMissedHostStats
+	}
+	//a host is offline once it has missed this many heartbeats in a row
+	mgr.hostTimeout = time.Duration(missedHostStats) * HostStatInterval
 	mgr.mgm = pers
 	mgr.log = logger.Wrap("HOST", log)
 	mgr.internalMsgs = make(chan internalMsg, 32)
 	mgr.requestChan = make(chan Message, 32)
+	mgr.closing = make(chan int64, 32)
+	mgr.register = make(chan registrationRequest, 32)
+	mgr.hStatChan = make(chan mgm.HostStat, 32)
+	mgr.rStatChan = make(chan regionStatReport, 64)
 	mgr.rMgr = rMgr
 	mgr.notify = notify
 	//ch := make(chan hostSession, 32)
@@ -41,7 +53,9 @@ func NewManager(port int, rMgr region.Manager, pers persist.MGMDB, notify notifi
 	regions := rMgr.GetRegions()
 	mgr.hosts = make(map[int64]mgm.Host)
 	mgr.hostStats = make(map[int64]mgm.HostStat)
-	mgr.hostConnections = make(map[int64]hostConn)
+	mgr.hostSeen = make(map[int64]time.Time)
+	mgr.hostConnections = make(map[int64]hostSession)
+	mgr.draining = make(map[int64]bool)
 	mgr.hMutex = &sync.Mutex{}
 	mgr.hsMutex = &sync.Mutex{}
 	mgr.hcMutex = &sync.Mutex{}
@@ -57,10 +71,13 @@ func NewManager(port int, rMgr region.Manager, pers persist.MGMDB, notify notifi
 		}
 	}
 
+	go mgr.process()
+
 	return mgr
 }
 
-// Manager is a central access point for Host operations
+// Manager is a central access point for Host operations.
+// Where more than one mutex is held they are taken in the order hMutex, hsMutex, hcMutex.
 type Manager struct {
 	listenPort      int
 	log             logger.Log
@@ -69,14 +86,28 @@ type Manager struct {
 	rMgr            region.Manager
 	notify          notifier
 	hosts           map[int64]mgm.Host
+	draining        map[int64]bool
 	hMutex          *sync.Mutex
-	hostConnections map[int64]hostConn
+	hostConnections map[int64]hostSession
 	hcMutex         *sync.Mutex
 	hostStats       map[int64]mgm.HostStat
+	hostSeen        map[int64]time.Time
 	hsMutex         *sync.Mutex
+	hostTimeout     time.Duration
+	policy          PlacementPolicy
 
 	requestChan  chan Message
 	internalMsgs chan internalMsg
+	closing      chan int64
+	register     chan registrationRequest
+	hStatChan    chan mgm.HostStat
+	rStatChan    chan regionStatReport
+}
+
+// regionStatReport is a region stat along with the host that reported it
+type regionStatReport struct {
+	host int64
+	stat mgm.RegionStat
 }
 
 type internalMsg struct {
@@ -116,20 +147,111 @@ func (m Manager) GetHostStats() []mgm.HostStat {
 	return t
 }
 
+// GetHost retrieves a host record from cache
+func (m Manager) GetHost(id int64) (mgm.Host, bool) {
+	m.hMutex.Lock()
+	defer m.hMutex.Unlock()
+	h, ok := m.hosts[id]
+	return h, ok
+}
+
+func (m Manager) isConnected(id int64) bool {
+	m.hcMutex.Lock()
+	defer m.hcMutex.Unlock()
+	_, ok := m.hostConnections[id]
+	return ok
+}
+
+func (m Manager) getHostByAddress(address string) (mgm.Host, bool) {
+	m.hMutex.Lock()
+	defer m.hMutex.Unlock()
+	for _, h := range m.hosts {
+		if h.Address == address {
+			return h, true
+		}
+	}
+	return mgm.Host{}, false
+}
+
 // StartRegionOnHost requests a region to be started with a matching host
 func (m Manager) StartRegionOnHost(region mgm.Region, host mgm.Host) error {
+	//regions placed before MGM allocated ports pick them up on their first start
+	if region.HTTPPort == 0 || region.ConsolePort == 0 {
+		r, err := m.AssignRegion(region.UUID, host.ID)
+		if err != nil {
+			return err
+		}
+		region = r
+	}
+	cfg := m.rMgr.GetStartConfig(region, host)
 	ch := make(chan error)
 	m.requestChan <- Message{
 		MessageType: "StartRegion",
 		Region:      region,
 		Host:        host,
+		Configs:     cfg.Configs,
+		Restart:     cfg.Restart,
+		Runtime:     cfg.Runtime,
+		Limits:      cfg.Limits,
+		Startup:     cfg.Startup,
 		response:    ch,
 	}
-	err, ok := <-ch
-	if ok {
-		return nil
+	//a closed channel indicates success
+	return <-ch
+}
+
+// GetRegionLog retrieves the last lines of output captured from a region process by its host
+func (m Manager) GetRegionLog(region mgm.Region, host mgm.Host, lines int) ([]string, error) {
+	ch := make(chan error)
+	var output []string
+	m.requestChan <- Message{
+		MessageType: "GetRegionLog",
+		Region:      region,
+		Host:        host,
+		Lines:       lines,
+		response:    ch,
+		reply: func(msg Message) {
+			output = msg.Output
+		},
+	}
+	//reply runs before the channel is closed
+	err := <-ch
+	return output, err
+}
+
+// RelayConsole passes a rest console call to a region through the node hosting it
+func (m Manager) RelayConsole(region mgm.Region, host mgm.Host, call mgm.ConsoleCall) (mgm.ConsoleCall, error) {
+	ch := make(chan error)
+	var result mgm.ConsoleCall
+	m.requestChan <- Message{
+		MessageType: "Console",
+		Region:      region,
+		Host:        host,
+		Console:     call,
+		response:    ch,
+		reply: func(msg Message) {
+			result = msg.Console
+		},
+	}
+	//reply runs before the channel is closed
+	err := <-ch
+	return result, err
+}
+
+// StopRegionOnHost requests a region be gracefully stopped on a specified host, optionally alerting it first.
+// Each stage of the stop is passed to report as the host completes it.
+func (m Manager) StopRegionOnHost(region mgm.Region, host mgm.Host, alert string, report func(string)) error {
+	ch := make(chan error)
+	m.requestChan <- Message{
+		MessageType: "StopRegion",
+		Region:      region,
+		Host:        host,
+		Message:     alert,
+		response:    ch,
+		report:      report,
 	}
-	return err
+	//a closed channel indicates success
+	return <-ch
 }
 
 // KillRegionOnHost requests a region to be killed on a specified host
@@ -141,11 +263,56 @@ func (m Manager) KillRegionOnHost(region mgm.Region, host mgm.Host) error {
 		Host:        host,
 		response:    ch,
 	}
-	err, ok := <-ch
-	if ok {
-		return nil
+	//a closed channel indicates success
+	return <-ch
+}
+
+// AddRegionToHost requests a host to provision a region
+func (m Manager) AddRegionToHost(region mgm.Region, host mgm.Host) error {
+	ch := make(chan error)
+	m.requestChan <- Message{
+		MessageType: "AddRegion",
+		Region:      region,
+		Host:        host,
+		response:    ch,
+	}
+	//a closed channel indicates success
+	return <-ch
+}
+
+// DeleteRegion purges a stopped region from its host, if it has one, and deletes the region record.
+// A host that is offline purges its copy when it reconnects.
+func (m Manager) DeleteRegion(r mgm.Region) error {
+	//removing a region from its host would kill it
+	if !m.rMgr.IsHalted(r.UUID) {
+		return errors.New("Region must be stopped before it is deleted")
+	}
+	if h, ok := m.GetHost(r.Host); ok {
+		if m.isConnected(h.ID) {
+			err := m.RemoveRegionFromHost(r, h)
+			if err != nil {
+				return fmt.Errorf("Could not remove region from host %v: %v", h.ID, err.Error())
+			}
+		}
+		_, err := m.UnassignRegion(r.UUID)
+		if err != nil {
+			return err
+		}
+	}
+	return m.rMgr.DeleteRegion(r.UUID)
+}
+
+// RemoveRegionFromHost requests a host to kill, if needed, and purge a region
+func (m Manager) RemoveRegionFromHost(region mgm.Region, host mgm.Host) error {
+	ch := make(chan error)
+	m.requestChan <- Message{
+		MessageType: "RemoveRegion",
+		Region:      region,
+		Host:        host,
+		response:    ch,
 	}
-	return err
+	//a closed channel indicates success
+	return <-ch
 }
 
 // RemoveHost removes a host registration from MGM
@@ -176,6 +343,7 @@ func (m Manager) RemoveHost(id int64) error {
 	//remove the host from the cache
 	delete(m.hostConnections, id)
 	delete(m.hostStats, id)
+	delete(m.hostSeen, id)
 	delete(m.hosts, id)
 
 	//notify any users of the change
@@ -186,8 +354,8 @@ func (m Manager) RemoveHost(id int64) error {
 	return nil
 }
 
-// AddHost creates a new host registration in MGM
-func (m Manager) AddHost(address string) error {
+// AddHost creates a new host registration in MGM, returning the enrollment secret for the node
+func (m Manager) AddHost(address string) (string, error) {
 	m.log.Info("Adding host at %v", address)
 	m.hMutex.Lock()
 	defer m.hMutex.Unlock()
@@ -195,13 +363,18 @@ func (m Manager) AddHost(address string) error {
 	//host cannot collide with existing addresses
 	for _, h := range m.hosts {
 		if h.Address == address {
-			return errors.New("There is already a host at that address")
+			return "", errors.New("There is already a host at that address")
 		}
 	}
 
-	id, err := m.mgm.InsertHost(address)
+	secret, err := newToken()
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	id, err := m.mgm.InsertHost(address, secret)
+	if err != nil {
+		return "", err
 	}
 
 	m.log.Info("New host %v at %v", id, address)
@@ -209,11 +382,48 @@ func (m Manager) AddHost(address string) error {
 	newHost := mgm.Host{}
 	newHost.ID = id
 	newHost.Address = address
+	newHost.Secret = secret
 
 	m.hosts[id] = newHost
 	m.hostStats[id] = mgm.HostStat{ID: id}
 	m.notify.HostUpdated(newHost)
-	return nil
+	return secret, nil
+}
+
+// RotateHostSecret issues a new enrollment secret for a host, disconnecting any node using the old one
+func (m Manager) RotateHostSecret(id int64) (string, error) {
+	m.log.Info("Rotating secret for host %v", id)
+	m.hMutex.Lock()
+	h, ok := m.hosts[id]
+	if !ok {
+		m.hMutex.Unlock()
+		return "", errors.New("Host does not exist")
+	}
+
+	secret, err := newToken()
+	if err != nil {
+		m.hMutex.Unlock()
+		return "", err
+	}
+
+	err = m.mgm.UpdateHostSecret(id, secret)
+	if err != nil {
+		m.hMutex.Unlock()
+		return "", err
+	}
+	h.Secret = secret
+	m.hosts[id] = h
+	m.hMutex.Unlock()
+
+	//the connected node authenticated with the old secret, make it enroll again
+	m.hcMutex.Lock()
+	if hs, ok := m.hostConnections[id]; ok {
+		hs.Close()
+	}
+	m.hcMutex.Unlock()
+
+	m.log.Info("Secret for host %v rotated", id)
+	return secret, nil
 }
 
 // UpdateHostStats consume an updated host stat, notifying the client manager as well
@@ -221,5 +431,142 @@ func (m Manager) UpdateHostStats(hs mgm.HostStat) {
 	m.hsMutex.Lock()
 	defer m.hsMutex.Unlock()
 	m.hostStats[hs.ID] = hs
+	if hs.Running {
+		m.hostSeen[hs.ID] = time.Now()
+	}
+	m.mgm.RecordHostStat(hs)
 	m.notify.HostStat(hs)
 }
+
+// GetHostMetrics retrieves the load history of a host at a rollup resolution
+func (m Manager) GetHostMetrics(id int64, resolution time.Duration, since time.Time) ([]mgm.MetricPoint, error) {
+	if _, ok := m.GetHost(id); !ok {
+		return nil, errors.New("Host does not exist")
+	}
+	return m.mgm.QueryMetrics(persist.MetricHost, strconv.FormatInt(id, 10), resolution, since)
+}
+
+// GetHostUptime reports how long the regions on a host were ready between from and until
+func (m Manager) GetHostUptime(id int64, from time.Time, until time.Time) (mgm.UptimeReport, error) {
+	if _, ok := m.GetHost(id); !ok {
+		return mgm.UptimeReport{}, errors.New("Host does not exist")
+	}
+	transitions, err := m.mgm.QueryHostTransitions(id, from, until)
+	if err != nil {
+		return mgm.UptimeReport{}, err
+	}
+	return region.NewUptimeReport("host", strconv.FormatInt(id, 10), transitions, from, until), nil
+}
+
+// markOffline flags a host and its regions as not running, if they are not already
+func (m Manager) markOffline(id int64) {
+	m.hsMutex.Lock()
+	delete(m.hostSeen, id)
+	stat, ok := m.hostStats[id]
+	m.hsMutex.Unlock()
+	if !ok || !stat.Running {
+		return
+	}
+
+	m.UpdateHostStats(mgm.HostStat{ID: id})
+	for _, r := range m.rMgr.GetRegions() {
+		if r.Host == id {
+			m.rMgr.RecordTransition(r.UUID, mgm.RegionStopped, "mgm", "Host offline")
+			m.rMgr.UpdateRegionStat(id, mgm.RegionStat{UUID: r.UUID})
+		}
+	}
+}
+
+// checkLiveness drops sessions for hosts that have not reported stats within the timeout
+func (m Manager) checkLiveness() {
+	m.hsMutex.Lock()
+	var silent []int64
+	for id, seen := range m.hostSeen {
+		if time.Since(seen) > m.hostTimeout {
+			silent = append(silent, id)
+		}
+	}
+	m.hsMutex.Unlock()
+
+	for _, id := range silent {
+		m.log.Info("Host %v has not reported in %v, marking offline", id, m.hostTimeout)
+		m.markOffline(id)
+		//closing the session fails any requests still waiting on the host
+		m.hcMutex.Lock()
+		if hs, ok := m.hostConnections[id]; ok {
+			hs.Close()
+		}
+		m.hcMutex.Unlock()
+	}
+}
+
+// errHostBusy is returned for requests to a session whose queue is full, rather than waiting on it
+var errHostBusy = errors.New("Host is busy, try again shortly")
+
+// route passes a request to the session for the target host.  It never waits on the session, which
+// may itself be waiting on this manager to take its stats.
+func (m Manager) route(msg Message) {
+	m.hcMutex.Lock()
+	hs, ok := m.hostConnections[msg.Host.ID]
+	m.hcMutex.Unlock()
+	if !ok {
+		msg.response <- errors.New("Host is not connected")
+		return
+	}
+	select {
+	case <-hs.done:
+		//a session that has ended will never take the request
+		msg.response <- errors.New("Host disconnected")
+	case hs.cmdMsgs <- msg:
+	default:
+		msg.response <- errHostBusy
+	}
+}
+
+func (m Manager) process() {
+	ticker := time.NewTicker(m.hostTimeout / 2)
+	for {
+		select {
+		case <-ticker.C:
+			m.checkLiveness()
+		case msg := <-m.requestChan:
+			m.route(msg)
+		case reg := <-m.register:
+			m.hMutex.Lock()
+			h, ok := m.hosts[reg.host.ID]
+			if !ok {
+				m.hMutex.Unlock()
+				continue
+			}
+			h.ExternalAddress = reg.reg.ExternalAddress
+			h.Hostname = reg.reg.Name
+			h.Slots = reg.reg.Slots
+			h.MinRegionPort = reg.reg.MinRegionPort
+			h.MaxRegionPort = reg.reg.MaxRegionPort
+			h.MinConsolePort = reg.reg.MinConsolePort
+			h.MaxConsolePort = reg.reg.MaxConsolePort
+			h.Labels = reg.reg.Labels
+			h.Builds = reg.reg.Builds
+			m.hosts[h.ID] = h
+			m.hMutex.Unlock()
+			m.mgm.UpdateHost(h)
+			m.notify.HostUpdated(h)
+			m.log.Info("Host %v registered as %v", h.ID, h.Hostname)
+		case hs := <-m.hStatChan:
+			m.UpdateHostStats(hs)
+		case rs := <-m.rStatChan:
+			m.rMgr.UpdateRegionStat(rs.host, rs.stat)
+		case id := <-m.closing:
+			m.hcMutex.Lock()
+			hs, ok := m.hostConnections[id]
+			delete(m.hostConnections, id)
+			m.hcMutex.Unlock()
+			//requests routed after the session ended, nothing is routed to it from here on
+			if ok {
+				hs.failQueued(errors.New("Host disconnected"))
+			}
+			m.markOffline(id)
+			m.log.Info("Host %v disconnected", id)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package host
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+func TestRouteNeverWaitsOnSession(t *testing.T) {
+	hs := hostSession{host: mgm.Host{ID: 1}, cmdMsgs: make(chan Message, 32), done: make(chan bool)}
+	m := Manager{
+		hostConnections: map[int64]hostSession{1: hs},
+		hcMutex:         &sync.Mutex{},
+		rStatChan:       make(chan regionStatReport, 64),
+	}
+
+	//the session is stuck handing stats to the manager, and has stopped taking requests
+	for len(m.rStatChan) < cap(m.rStatChan) {
+		m.rStatChan <- regionStatReport{1, mgm.RegionStat{UUID: uuid.NewV4()}}
+	}
+	for len(hs.cmdMsgs) < cap(hs.cmdMsgs) {
+		hs.cmdMsgs <- Message{MessageType: "KillRegion"}
+	}
+
+	route := func(hostID int64) error {
+		ch := make(chan error, 1)
+		go m.route(Message{MessageType: "KillRegion", Host: mgm.Host{ID: hostID}, response: ch})
+		select {
+		case err := <-ch:
+			return err
+		case <-time.After(time.Second):
+			t.Fatal("routing blocked on the session")
+			return nil
+		}
+	}
+
+	if err := route(1); err != errHostBusy {
+		t.Errorf("request to a stuck session: got %v, want %v", err, errHostBusy)
+	}
+	if err := route(2); err == nil {
+		t.Error("request to an unconnected host succeeded")
+	}
+
+	//once the session drains its queue, requests reach it again
+	<-hs.cmdMsgs
+	ch := make(chan error, 1)
+	m.route(Message{MessageType: "StartRegion", Host: mgm.Host{ID: 1}, response: ch})
+	if len(ch) != 0 {
+		t.Errorf("request to a session with room failed: %v", <-ch)
+	}
+
+	//an ended session is reported as such, not as busy
+	for len(hs.cmdMsgs) < cap(hs.cmdMsgs) {
+		hs.cmdMsgs <- Message{MessageType: "KillRegion"}
+	}
+	close(hs.done)
+	if err := route(1); err == nil || err == errHostBusy {
+		t.Errorf("request to an ended session: got %v, want disconnected", err)
+	}
+}
@@ -0,0 +1,155 @@
+package host
+
+import (
+	"errors"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+// AssignRegion places a region on a host, allocating it a free port pair from the ranges the host reported
+func (m Manager) AssignRegion(id uuid.UUID, hostID int64) (mgm.Region, error) {
+	m.hMutex.Lock()
+
+	r, ok := m.rMgr.GetRegion(id)
+	if !ok {
+		m.hMutex.Unlock()
+		return mgm.Region{}, errors.New("Region does not exist")
+	}
+	h, ok := m.hosts[hostID]
+	if !ok {
+		m.hMutex.Unlock()
+		return r, errors.New("Host does not exist")
+	}
+
+	regions := m.rMgr.GetRegions()
+	httpPort, consolePort, err := allocatePorts(h, r, regions)
+	if err != nil {
+		m.hMutex.Unlock()
+		return r, err
+	}
+	//the slot is taken under the lock, placements racing for the last one cannot both have it
+	if r.Host != h.ID && regionsOn(h.ID, r, regions) >= h.Slots {
+		m.hMutex.Unlock()
+		return r, errNoSlots
+	}
+
+	var updated []mgm.Host
+	//release the region from its previous host
+	if old, ok := m.hosts[r.Host]; ok && r.Host != h.ID {
+		old.Regions = removeRegionID(old.Regions, r.UUID)
+		m.hosts[old.ID] = old
+		updated = append(updated, old)
+	}
+	if r.Host != h.ID {
+		h.Regions = append(h.Regions, r.UUID)
+		m.hosts[h.ID] = h
+		updated = append(updated, h)
+	}
+
+	r.Host = h.ID
+	r.HTTPPort = httpPort
+	r.ConsolePort = consolePort
+	//cached under the lock, so the next allocation sees these ports taken
+	m.rMgr.CacheRegion(r)
+	m.hMutex.Unlock()
+
+	m.rMgr.PublishRegion(r)
+	for _, uh := range updated {
+		m.notify.HostUpdated(uh)
+	}
+	m.log.Info("Region %v assigned to host %v on ports %v/%v", r.UUID, h.ID, httpPort, consolePort)
+	return r, nil
+}
+
+// UnassignRegion removes a region from its host, releasing its ports
+func (m Manager) UnassignRegion(id uuid.UUID) (mgm.Region, error) {
+	m.hMutex.Lock()
+
+	r, ok := m.rMgr.GetRegion(id)
+	if !ok {
+		m.hMutex.Unlock()
+		return mgm.Region{}, errors.New("Region does not exist")
+	}
+
+	var updated []mgm.Host
+	if h, ok := m.hosts[r.Host]; ok {
+		h.Regions = removeRegionID(h.Regions, r.UUID)
+		m.hosts[h.ID] = h
+		updated = append(updated, h)
+	}
+
+	r.Host = 0
+	r.HTTPPort = 0
+	r.ConsolePort = 0
+	m.rMgr.CacheRegion(r)
+	m.hMutex.Unlock()
+
+	m.rMgr.PublishRegion(r)
+	for _, uh := range updated {
+		m.notify.HostUpdated(uh)
+	}
+	m.log.Info("Region %v released from its host", r.UUID)
+	return r, nil
+}
+
+// errNoSlots is returned when a host is already running as many regions as it has slots for
+var errNoSlots = errors.New("Host has no free slots")
+
+// regionsOn counts the regions on a host, other than r
+func regionsOn(hostID int64, r mgm.Region, regions []mgm.Region) int {
+	count := 0
+	for _, reg := range regions {
+		if reg.Host == hostID && reg.UUID != r.UUID {
+			count++
+		}
+	}
+	return count
+}
+
+// allocatePorts finds a free region/console port pair on a host.  A region already on the host keeps its ports.
+func allocatePorts(h mgm.Host, r mgm.Region, regions []mgm.Region) (int, int, error) {
+	if h.MaxRegionPort == 0 || h.MaxConsolePort == 0 {
+		return 0, 0, errors.New("Host has not reported its port ranges, it must connect at least once")
+	}
+
+	if r.Host == h.ID &&
+		r.HTTPPort >= h.MinRegionPort && r.HTTPPort <= h.MaxRegionPort &&
+		r.ConsolePort >= h.MinConsolePort && r.ConsolePort <= h.MaxConsolePort {
+		return r.HTTPPort, r.ConsolePort, nil
+	}
+
+	httpUsed := make(map[int]bool)
+	consoleUsed := make(map[int]bool)
+	for _, reg := range regions {
+		if reg.Host != h.ID || reg.UUID == r.UUID {
+			continue
+		}
+		httpUsed[reg.HTTPPort] = true
+		consoleUsed[reg.ConsolePort] = true
+	}
+
+	//nodes require both ranges to be the same size, ports are handed out in pairs
+	for i := 0; i <= h.MaxRegionPort-h.MinRegionPort; i++ {
+		httpPort := h.MinRegionPort + i
+		consolePort := h.MinConsolePort + i
+		if consolePort > h.MaxConsolePort {
+			break
+		}
+		if !httpUsed[httpPort] && !consoleUsed[consolePort] {
+			return httpPort, consolePort, nil
+		}
+	}
+
+	return 0, 0, errors.New("Host has no free ports")
+}
+
+func removeRegionID(ids []uuid.UUID, id uuid.UUID) []uuid.UUID {
+	result := []uuid.UUID{}
+	for _, r := range ids {
+		if r != id {
+			result = append(result, r)
+		}
+	}
+	return result
+}
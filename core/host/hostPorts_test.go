@@ -0,0 +1,102 @@
+package host
+
+import (
+	"testing"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+func TestAllocatePorts(t *testing.T) {
+	h := mgm.Host{ID: 1, MinRegionPort: 9000, MaxRegionPort: 9002, MinConsolePort: 9100, MaxConsolePort: 9102}
+	on := func(host int64, http int, console int) mgm.Region {
+		return mgm.Region{UUID: uuid.NewV4(), Host: host, HTTPPort: http, ConsolePort: console}
+	}
+	placed := on(1, 9001, 9101)
+
+	tests := []struct {
+		name    string
+		h       mgm.Host
+		r       mgm.Region
+		regions []mgm.Region
+		http    int
+		console int
+		fail    bool
+	}{
+		{
+			name: "host without ranges",
+			h:    mgm.Host{ID: 1},
+			r:    on(0, 0, 0),
+			fail: true,
+		},
+		{
+			name:    "empty host takes the first pair",
+			h:       h,
+			r:       on(0, 0, 0),
+			http:    9000,
+			console: 9100,
+		},
+		{
+			name:    "ports are allocated in pairs",
+			h:       h,
+			r:       on(0, 0, 0),
+			regions: []mgm.Region{on(1, 9000, 9100)},
+			http:    9001,
+			console: 9101,
+		},
+		{
+			name:    "a pair is skipped when either half is taken",
+			h:       h,
+			r:       on(0, 0, 0),
+			regions: []mgm.Region{on(1, 9000, 9105), on(1, 9005, 9101)},
+			http:    9002,
+			console: 9102,
+		},
+		{
+			name:    "regions on other hosts do not hold ports",
+			h:       h,
+			r:       on(0, 0, 0),
+			regions: []mgm.Region{on(2, 9000, 9100)},
+			http:    9000,
+			console: 9100,
+		},
+		{
+			name:    "a region on the host keeps its ports",
+			h:       h,
+			r:       placed,
+			regions: []mgm.Region{on(1, 9000, 9100), placed},
+			http:    9001,
+			console: 9101,
+		},
+		{
+			name:    "exhausted range",
+			h:       h,
+			r:       on(0, 0, 0),
+			regions: []mgm.Region{on(1, 9000, 9100), on(1, 9001, 9101), on(1, 9002, 9102)},
+			fail:    true,
+		},
+		{
+			name:    "shorter console range limits the pairs",
+			h:       mgm.Host{ID: 1, MinRegionPort: 9000, MaxRegionPort: 9002, MinConsolePort: 9100, MaxConsolePort: 9100},
+			r:       on(0, 0, 0),
+			regions: []mgm.Region{on(1, 9000, 9100)},
+			fail:    true,
+		},
+	}
+	for _, tt := range tests {
+		http, console, err := allocatePorts(tt.h, tt.r, tt.regions)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%v: got %v/%v, want an error", tt.name, http, console)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tt.name, err)
+			continue
+		}
+		if http != tt.http || console != tt.console {
+			t.Errorf("%v: got %v/%v, want %v/%v", tt.name, http, console, tt.http, tt.console)
+		}
+	}
+}
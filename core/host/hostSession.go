@@ -1,110 +1,277 @@
 package host
 
 import (
-	"net"
+	"errors"
+	"fmt"
 
+	"github.com/gorilla/websocket"
 	"github.com/m-o-s-e-s/mgm/core/logger"
+	"github.com/m-o-s-e-s/mgm/core/region"
 	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
 )
 
 type hostSession struct {
 	host    mgm.Host
-	Running bool
-	conn    net.Conn
+	conn    *websocket.Conn
 	cmdMsgs chan Message
+	done    chan bool
 	log     logger.Log
+	rMgr    region.Manager
+}
 
-	regions []mgm.Region
+// assignedRegions retrieves the regions MGM currently has assigned to this host
+func (hs hostSession) assignedRegions() []mgm.Region {
+	var regions []mgm.Region
+	for _, r := range hs.rMgr.GetRegions() {
+		if r.Host == hs.host.ID {
+			regions = append(regions, r)
+		}
+	}
+	return regions
 }
 
-func (ns hostSession) process(closing chan<- int64, register chan<- registrationRequest, hStatChan chan<- mgm.HostStat, rStatChan chan<- mgm.RegionStat) {
-	/*	readMsgs := make(chan Message, 32)
-		writeMsgs := make(chan Message, 32)
-		nc := Comms{
-			Connection: ns.conn,
-			Closing:    make(chan bool),
-			Log:        ns.log,
+// assignedStats keeps only the stats for regions assigned to the host, a node cannot report on regions it does not hold
+func assignedStats(hostID int64, stats []mgm.RegionStat, regions []mgm.Region) []mgm.RegionStat {
+	assigned := make(map[uuid.UUID]bool)
+	for _, r := range regions {
+		if r.Host == hostID {
+			assigned[r.UUID] = true
+		}
+	}
+	var own []mgm.RegionStat
+	for _, stat := range stats {
+		if assigned[stat.UUID] {
+			own = append(own, stat)
 		}
-		go nc.ReadConnection(readMsgs)
-		go nc.WriteConnection(writeMsgs)
+	}
+	return own
+}
+
+// Close terminates the connection to the node, which ends the session
+func (hs hostSession) Close() {
+	hs.conn.Close()
+}
 
-		defer ns.conn.Close()
+// failQueued fails requests routed to the session that it will never process
+func (hs hostSession) failQueued(err error) {
+	for {
+		select {
+		case msg := <-hs.cmdMsgs:
+			msg.response <- err
+		default:
+			return
+		}
+	}
+}
 
-		//prepare for request tracking, so we might report results back to users
-		var requestNum uint
-		pendingRequests := make(map[uint]Message)
+func (hs hostSession) process(closing chan<- int64, register chan<- registrationRequest, hStatChan chan<- mgm.HostStat, rStatChan chan<- regionStatReport) {
+	readMsgs := make(chan Message, 32)
+	writeMsgs := make(chan Message, 32)
+	nc := Comms{
+		Connection: hs.conn,
+		Closing:    make(chan bool, 1),
+		Log:        hs.log,
+	}
+	go nc.ReadConnection(readMsgs)
+	go nc.WriteConnection(writeMsgs)
 
-		for {
+	defer hs.conn.Close()
+	defer close(writeMsgs)
 
-			select {
-			case <-nc.Closing:
-				ns.log.Info("disconnected")
-				//notify manager that we disconnected
-				closing <- ns.host.ID
+	//prepare for request tracking, so we might report results back to users
+	var requestNum uint
+	pendingRequests := make(map[uint]Message)
+	//track sends a request of our own, such as a reconcile, logging how the node answers it
+	track := func(msg Message) {
+		requestNum++
+		msg.ID = requestNum
+		ch := make(chan error, 1)
+		msg.response = ch
+		pendingRequests[msg.ID] = msg
+		writeMsgs <- msg
+		go func() {
+			if err := <-ch; err != nil {
+				hs.log.Error("%v %v failed, the node is out of sync until it reconnects: %v", msg.MessageType, msg.Region.UUID, err.Error())
 				return
+			}
+			hs.log.Info("%v %v complete", msg.MessageType, msg.Region.UUID)
+		}()
+	}
+
+	//nothing is exchanged until the node registers with a compatible protocol
+	registered := false
+	notRegistered := errors.New("Host has not registered")
+	var peer Registration
 
-			case msg := <-ns.cmdMsgs:
-				// Messages coming from MGM
-				if !ns.Running {
-					msg.response <- errors.New("Operation ignored, host is offline")
-					ns.log.Info("Ignoring request of type %v, host is not connected", msg.MessageType)
+	for {
+		select {
+		case <-nc.Closing:
+			hs.log.Info("disconnected")
+			//fail anything still waiting on this node
+			for _, req := range pendingRequests {
+				req.response <- errors.New("Host disconnected")
+			}
+			close(hs.done)
+			hs.failQueued(errors.New("Host disconnected"))
+			//notify manager that we disconnected
+			closing <- hs.host.ID
+			return
+
+		case msg := <-hs.cmdMsgs:
+			// Messages coming from MGM
+			if !registered {
+				msg.response <- notRegistered
+				hs.log.Info("Ignoring request of type %v, host is not registered", msg.MessageType)
+				continue
+			}
+			// confirm the node understands this request
+			if c, ok := requiredCapability[msg.MessageType]; ok && !peer.HasCapability(c) {
+				msg.response <- fmt.Errorf("Host does not support %v, the node must be upgraded", msg.MessageType)
+				hs.log.Info("Ignoring request of type %v, node lacks capability %v", msg.MessageType, c)
+				continue
+			}
+			// confirm we are not pending on an identical request
+			duplicate := false
+			for _, req := range pendingRequests {
+				if !concurrentRequests[msg.MessageType] && req.MessageType == msg.MessageType && req.Region.UUID == msg.Region.UUID {
+					duplicate = true
+					break
+				}
+			}
+			if duplicate {
+				msg.response <- fmt.Errorf("Pending operation of type %v already in progress", msg.MessageType)
+				hs.log.Info("Ignoring request of type %v, matching request already in progress", msg.MessageType)
+				continue
+			}
+			//no pending detected, pass it through
+			requestNum++
+			msg.ID = requestNum
+			pendingRequests[msg.ID] = msg
+			writeMsgs <- msg
+
+		case nmsg := <-readMsgs:
+			// Messages coming from the host
+			if !registered && nmsg.MessageType != "Register" {
+				hs.log.Info("Ignoring message of type %v, host is not registered", nmsg.MessageType)
+				continue
+			}
+			switch nmsg.MessageType {
+			case "Register":
+				version, err := Negotiate(nmsg.Register)
+				if err != nil {
+					//leave the session unregistered, so requests fail with the reason
+					hs.log.Error("Incompatible node: %v", err.Error())
+					notRegistered = fmt.Errorf("Host is incompatible: %v", err.Error())
+					writeMsgs <- Message{MessageType: "RegisterRejected", Message: err.Error()}
 					continue
 				}
-				// confirm we are not pending on an identical request
-				for _, req := range pendingRequests {
-					if req.MessageType == msg.MessageType && req.Region.UUID == msg.Region.UUID {
-						msg.response <- fmt.Errorf("Pending operation of type %v already in progress", req.MessageType)
-						ns.log.Info("Ignoring request of type %v, matching request already in progress", req.MessageType)
-						continue
-					}
+				registered = true
+				peer = nmsg.Register
+				hs.log.Info("Registered with protocol version %v, capabilities %v", version, peer.Capabilities)
+				writeMsgs <- Message{MessageType: "RegisterAccepted", Register: NewRegistration()}
+				register <- registrationRequest{nmsg.Register, hs.host}
+			case "HostStats":
+				hStats := nmsg.HStats
+				hStats.ID = hs.host.ID
+				hStatChan <- hStats
+			case "RegionStats":
+				stats := assignedStats(hs.host.ID, []mgm.RegionStat{nmsg.RStats}, hs.rMgr.GetRegions())
+				if len(stats) == 0 {
+					hs.log.Info("Discarding stats for region %v not assigned to this host", nmsg.RStats.UUID)
 				}
-				//no pending detected, pass it through
-				msg.ID = requestNum
-				pendingRequests[msg.ID] = msg
-				writeMsgs <- msg
-
-			case nmsg := <-readMsgs:
-				// Messages coming from the host
-				switch nmsg.MessageType {
-
-				case "Register":
-					reg := nmsg.Register
-					//update existing host
-					register <- registrationRequest{reg, ns.host}
-
-				case "HostStats":
-					hStats := nmsg.HStats
-					hStats.ID = ns.host.ID
-					hStatChan <- hStats
-				case "RegionStats":
-					rStats := nmsg.RStats
-					//track stats value
-					rStatChan <- rStats
-				case "GetRegions":
-					ns.log.Info("requesting regions list")
-					for _, r := range ns.regions {
-						if r.Host == ns.host.ID {
-							writeMsgs <- Message{MessageType: "AddRegion", Region: r}
-						}
-					}
-					ns.log.Info("Region list served")
-				case "Success":
-					//an MGM request has succeeded
-					if req, ok := pendingRequests[nmsg.ID]; ok {
-						close(req.response)
-						delete(pendingRequests, nmsg.ID)
+				for _, stat := range stats {
+					rStatChan <- regionStatReport{hs.host.ID, stat}
+				}
+			case "RegionEvent":
+				hs.rMgr.RegionEvent(hs.host.ID, nmsg.Event)
+			case "GetRegions":
+				hs.log.Info("requesting regions list")
+				for _, r := range hs.assignedRegions() {
+					track(Message{MessageType: "AddRegion", Region: r})
+				}
+				hs.log.Info("Region list served")
+			case "RegionInventory":
+				hs.log.Info("reconciling inventory of %v region(s)", len(nmsg.Inventory))
+				for _, msg := range hs.reconcile(nmsg.Inventory) {
+					track(msg)
+				}
+				//regions the node holds but MGM placed elsewhere are being removed, their stats are not forwarded
+				for _, stat := range assignedStats(hs.host.ID, nmsg.Inventory, hs.rMgr.GetRegions()) {
+					rStatChan <- regionStatReport{hs.host.ID, stat}
+				}
+			case "Progress":
+				//a long running MGM request has reached a new stage
+				if req, ok := pendingRequests[nmsg.ID]; ok {
+					hs.log.Info("%v %v: %v", req.MessageType, req.Region.UUID, nmsg.Message)
+					if req.report != nil {
+						req.report(nmsg.Message)
 					}
-				case "Failure":
-					//an MGM request has failed
-					if req, ok := pendingRequests[nmsg.ID]; ok {
-						req.response <- errors.New(nmsg.Message)
-						delete(pendingRequests, nmsg.ID)
+				}
+			case "Success":
+				//an MGM request has succeeded
+				if req, ok := pendingRequests[nmsg.ID]; ok {
+					if req.reply != nil {
+						req.reply(nmsg)
 					}
-				default:
-					ns.log.Info("Received invalid message: %s", nmsg.MessageType)
+					close(req.response)
+					delete(pendingRequests, nmsg.ID)
+				}
+			case "Failure":
+				//an MGM request has failed
+				if req, ok := pendingRequests[nmsg.ID]; ok {
+					req.response <- errors.New(nmsg.Message)
+					delete(pendingRequests, nmsg.ID)
+				} else {
+					hs.log.Error("Untracked failure: %v", nmsg.Message)
 				}
+			default:
+				hs.log.Info("Received invalid message: %s", nmsg.MessageType)
 			}
+		}
+	}
+}
 
+// reconcile compares a node inventory against the regions MGM has assigned to the host,
+// producing the messages that bring the node back in line
+func (hs hostSession) reconcile(inventory []mgm.RegionStat) []Message {
+	msgs := reconcileInventory(hs.host.ID, inventory, hs.rMgr.GetRegions())
+	for _, msg := range msgs {
+		if msg.MessageType == "RemoveRegion" {
+			//the node removes, and if needed kills, regions that no longer belong to it
+			hs.log.Info("Region %v no longer belongs on this host, removing", msg.Region.UUID)
+		} else {
+			hs.log.Info("Region %v is missing from this host, adding", msg.Region.UUID)
 		}
-	*/
+	}
+	return msgs
+}
+
+// reconcileInventory removes regions the node holds that are not assigned to host hostID, and adds
+// assigned regions the node is missing
+func reconcileInventory(hostID int64, inventory []mgm.RegionStat, regions []mgm.Region) []Message {
+	var msgs []Message
+
+	assigned := make(map[uuid.UUID]bool)
+	for _, r := range regions {
+		if r.Host == hostID {
+			assigned[r.UUID] = true
+		}
+	}
+
+	present := make(map[uuid.UUID]bool)
+	for _, stat := range inventory {
+		present[stat.UUID] = true
+		if !assigned[stat.UUID] {
+			msgs = append(msgs, Message{MessageType: "RemoveRegion", Region: mgm.Region{UUID: stat.UUID}})
+		}
+	}
+
+	for _, r := range regions {
+		if r.Host == hostID && !present[r.UUID] {
+			msgs = append(msgs, Message{MessageType: "AddRegion", Region: r})
+		}
+	}
+
+	return msgs
 }
@@ -0,0 +1,90 @@
+package host
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+func TestAssignedStats(t *testing.T) {
+	own := mgm.Region{UUID: uuid.NewV4(), Host: 1}
+	foreign := mgm.Region{UUID: uuid.NewV4(), Host: 2}
+	unassigned := mgm.Region{UUID: uuid.NewV4()}
+	regions := []mgm.Region{own, foreign, unassigned}
+	stat := func(r mgm.Region) mgm.RegionStat {
+		return mgm.RegionStat{UUID: r.UUID, Running: true}
+	}
+
+	tests := []struct {
+		name  string
+		stats []mgm.RegionStat
+		want  []uuid.UUID
+	}{
+		{"own region", []mgm.RegionStat{stat(own)}, []uuid.UUID{own.UUID}},
+		{"region on another host", []mgm.RegionStat{stat(foreign)}, nil},
+		{"unassigned region", []mgm.RegionStat{stat(unassigned)}, nil},
+		{"unknown region", []mgm.RegionStat{{UUID: uuid.NewV4()}}, nil},
+		{"inventory keeps only assigned regions", []mgm.RegionStat{stat(foreign), stat(own), stat(unassigned)}, []uuid.UUID{own.UUID}},
+		{"empty inventory", nil, nil},
+	}
+	for _, tt := range tests {
+		got := assignedStats(1, tt.stats, regions)
+		if len(got) != len(tt.want) {
+			t.Errorf("%v: got %v stats, want %v", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for i, id := range tt.want {
+			if got[i].UUID != id {
+				t.Errorf("%v: got %v, want %v", tt.name, got[i].UUID, id)
+			}
+		}
+	}
+}
+
+func TestReconcileInventory(t *testing.T) {
+	own := mgm.Region{UUID: uuid.NewV4(), Host: 1}
+	missing := mgm.Region{UUID: uuid.NewV4(), Host: 1}
+	foreign := mgm.Region{UUID: uuid.NewV4(), Host: 2}
+	unassigned := mgm.Region{UUID: uuid.NewV4()}
+	stat := func(r mgm.Region) mgm.RegionStat {
+		return mgm.RegionStat{UUID: r.UUID, Running: true}
+	}
+	unknown := mgm.RegionStat{UUID: uuid.NewV4(), Running: true}
+
+	tests := []struct {
+		name      string
+		regions   []mgm.Region
+		inventory []mgm.RegionStat
+		add       []uuid.UUID
+		remove    []uuid.UUID
+	}{
+		{"in sync", []mgm.Region{own}, []mgm.RegionStat{stat(own)}, nil, nil},
+		{"assigned but missing", []mgm.Region{own, missing}, []mgm.RegionStat{stat(own)}, []uuid.UUID{missing.UUID}, nil},
+		{"present but unassigned", []mgm.Region{own, unassigned}, []mgm.RegionStat{stat(own), stat(unassigned)}, nil, []uuid.UUID{unassigned.UUID}},
+		{"assigned to another host", []mgm.Region{own, foreign}, []mgm.RegionStat{stat(own), stat(foreign)}, nil, []uuid.UUID{foreign.UUID}},
+		{"unknown to MGM", []mgm.Region{own}, []mgm.RegionStat{stat(own), unknown}, nil, []uuid.UUID{unknown.UUID}},
+		{"empty node", []mgm.Region{own, missing, foreign}, nil, []uuid.UUID{own.UUID, missing.UUID}, nil},
+		{"nothing assigned", []mgm.Region{foreign}, []mgm.RegionStat{stat(foreign)}, nil, []uuid.UUID{foreign.UUID}},
+	}
+	for _, tt := range tests {
+		var add, remove []uuid.UUID
+		for _, msg := range reconcileInventory(1, tt.inventory, tt.regions) {
+			switch msg.MessageType {
+			case "AddRegion":
+				add = append(add, msg.Region.UUID)
+			case "RemoveRegion":
+				remove = append(remove, msg.Region.UUID)
+			default:
+				t.Errorf("%v: unexpected %v", tt.name, msg.MessageType)
+			}
+		}
+		if !reflect.DeepEqual(add, tt.add) {
+			t.Errorf("%v: added %v, want %v", tt.name, add, tt.add)
+		}
+		if !reflect.DeepEqual(remove, tt.remove) {
+			t.Errorf("%v: removed %v, want %v", tt.name, remove, tt.remove)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package host
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+)
+
+// MigrateRegion moves a region onto the target host on behalf of actor, restarting it there if it was running.
+// Progress is reported through the report callback.  If the target cannot take the region,
+// the region is returned to its original host.  A region is only moved off of an offline host when forced,
+// as the host may be cut off from MGM while still running it.
+func (m Manager) MigrateRegion(r mgm.Region, target mgm.Host, actor string, force bool, report func(string)) error {
+	if r.Host == target.ID {
+		return errors.New("Region is already on that host")
+	}
+	if m.rMgr.GetRegionState(r.UUID) == mgm.RegionStopping {
+		return errors.New("Region is stopping, it can be moved once it has stopped")
+	}
+
+	wasRunning := !m.rMgr.IsHalted(r.UUID)
+	source, hasSource := m.GetHost(r.Host)
+	reason := fmt.Sprintf("Moving to host %v", target.ID)
+
+	if hasSource && !m.isConnected(source.ID) {
+		if !force {
+			//the same region on two hosts would be on the grid twice, the job reports why it was refused
+			return fmt.Errorf("Host %v is offline and may still be running the region, force the move to start it elsewhere", source.ID)
+		}
+		//reconciliation purges the stale copy when the host reconnects
+		report(fmt.Sprintf("Host %v is offline, its copy of the region will be purged when it reconnects", source.ID))
+	} else if hasSource {
+		if wasRunning {
+			report(fmt.Sprintf("Stopping region on host %v", source.ID))
+			err := m.haltRegion(r, source, actor, reason, report)
+			if err != nil {
+				return fmt.Errorf("Could not halt region on host %v: %v", source.ID, err.Error())
+			}
+		}
+		report(fmt.Sprintf("Removing region from host %v", source.ID))
+		err := m.RemoveRegionFromHost(r, source)
+		if err != nil {
+			err = fmt.Errorf("Could not remove region from host %v: %v", source.ID, err.Error())
+			if !wasRunning {
+				return err
+			}
+			if rerr := m.startRegion(r, source, actor, "Move failed, restarting"); rerr != nil {
+				m.log.Error("Restart of region %v on host %v failed: %v", r.UUID, source.ID, rerr.Error())
+				return fmt.Errorf("%v, and restart failed: %v", err.Error(), rerr.Error())
+			}
+			return err
+		}
+	}
+
+	//undo everything past this point, putting the region back where it was
+	rollback := func(cause error) error {
+		report(fmt.Sprintf("Migration failed: %v, rolling back", cause.Error()))
+		m.RemoveRegionFromHost(r, target)
+		if !hasSource {
+			m.UnassignRegion(r.UUID)
+			return cause
+		}
+		reg, err := m.AssignRegion(r.UUID, source.ID)
+		if err == nil {
+			err = m.AddRegionToHost(reg, source)
+		}
+		if err == nil && wasRunning {
+			err = m.startRegion(reg, source, actor, "Move rolled back")
+		}
+		if err != nil {
+			m.log.Error("Rollback of region %v to host %v failed: %v", r.UUID, source.ID, err.Error())
+			return fmt.Errorf("%v, and rollback failed: %v", cause.Error(), err.Error())
+		}
+		return cause
+	}
+
+	report(fmt.Sprintf("Assigning region to host %v", target.ID))
+	reg, err := m.AssignRegion(r.UUID, target.ID)
+	if err != nil {
+		return rollback(err)
+	}
+
+	report(fmt.Sprintf("Adding region to host %v", target.ID))
+	err = m.AddRegionToHost(reg, target)
+	if err != nil {
+		return rollback(err)
+	}
+
+	if wasRunning {
+		report(fmt.Sprintf("Starting region on host %v", target.ID))
+		err = m.startRegion(reg, target, actor, fmt.Sprintf("Moved to host %v", target.ID))
+		if err != nil {
+			return rollback(err)
+		}
+	}
+
+	report("Migration complete")
+	return nil
+}
+
+// haltRegion stops a region on its host on behalf of actor, killing it if it will not stop gracefully
+func (m Manager) haltRegion(r mgm.Region, h mgm.Host, actor string, reason string, report func(string)) error {
+	undo, err := m.rMgr.RequestTransition(r.UUID, mgm.RegionStopping, actor, reason)
+	if err != nil {
+		return err
+	}
+	err = m.StopRegionOnHost(r, h, "This region is being moved and will restart shortly", report)
+	if err != nil {
+		report(fmt.Sprintf("Graceful stop failed: %v, halting region", err.Error()))
+		err = m.KillRegionOnHost(r, h)
+	}
+	if err != nil {
+		undo(fmt.Sprintf("Stop failed: %v", err.Error()))
+		return err
+	}
+	//the region must be seen stopped before it may start again, which the host may not have reported yet
+	m.rMgr.RecordTransition(r.UUID, mgm.RegionStopped, actor, reason)
+	return nil
+}
+
+// startRegion starts a region on a host on behalf of actor, undoing the transition if the host refuses
+func (m Manager) startRegion(r mgm.Region, h mgm.Host, actor string, reason string) error {
+	undo, err := m.rMgr.RequestTransition(r.UUID, mgm.RegionStarting, actor, reason)
+	if err != nil {
+		return err
+	}
+	err = m.StartRegionOnHost(r, h)
+	if err != nil {
+		undo(fmt.Sprintf("Start failed: %v", err.Error()))
+	}
+	return err
+}
@@ -0,0 +1,291 @@
+package host
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/core/persist"
+	"github.com/m-o-s-e-s/mgm/core/region"
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+type testLog struct{}
+
+func (testLog) Trace(format string, v ...interface{}) {}
+func (testLog) Debug(format string, v ...interface{}) {}
+func (testLog) Info(format string, v ...interface{})  {}
+func (testLog) Warn(format string, v ...interface{})  {}
+func (testLog) Error(format string, v ...interface{}) {}
+func (testLog) Fatal(format string, v ...interface{}) {}
+
+// testNotifier discards notifications for both the host and region managers
+type testNotifier struct{}
+
+func (testNotifier) HostRemoved(int64)           {}
+func (testNotifier) HostUpdated(mgm.Host)        {}
+func (testNotifier) HostStat(mgm.HostStat)       {}
+func (testNotifier) RegionUpdated(mgm.Region)    {}
+func (testNotifier) RegionDeleted(mgm.Region)    {}
+func (testNotifier) RegionStat(mgm.RegionStat)   {}
+func (testNotifier) RegionEvent(mgm.RegionEvent) {}
+
+// testStore holds regions in memory, standing in for the database
+type testStore struct {
+	regions []mgm.Region
+	states  map[uuid.UUID]string
+}
+
+func (s testStore) QueryRegions() []mgm.Region                        { return s.regions }
+func (s testStore) PersistRegion(mgm.Region)                          {}
+func (s testStore) PurgeRegion(uuid.UUID) error                       { return nil }
+func (s testStore) QueryDefaultConfigs() []mgm.ConfigOption           { return nil }
+func (s testStore) QueryConfigs(uuid.UUID) []mgm.ConfigOption         { return nil }
+func (s testStore) RecordRegionStat(mgm.RegionStat)                   {}
+func (s testStore) RecordRegionTransition(mgm.RegionTransition) error { return nil }
+func (s testStore) QueryLastRegionStates() (map[uuid.UUID]string, error) {
+	return s.states, nil
+}
+func (s testStore) QueryMetrics(string, string, time.Duration, time.Time) ([]mgm.MetricPoint, error) {
+	return nil, nil
+}
+func (s testStore) QueryRegionTransitions(uuid.UUID, time.Time, time.Time) ([]mgm.RegionTransition, error) {
+	return nil, nil
+}
+
+// testNode stands in for the sessions of every connected host, answering requests routed to them.
+// A request matching a failure, keyed by message type and host id such as "AddRegion 2", fails once.
+type testNode struct {
+	mutex   *sync.Mutex
+	regions map[int64]map[uuid.UUID]mgm.Region
+	running map[uuid.UUID]int64
+	fail    map[string]error
+}
+
+func (n testNode) serve(requests <-chan Message) {
+	for msg := range requests {
+		n.mutex.Lock()
+		key := fmt.Sprintf("%v %v", msg.MessageType, msg.Host.ID)
+		if err, ok := n.fail[key]; ok {
+			delete(n.fail, key)
+			n.mutex.Unlock()
+			msg.response <- err
+			continue
+		}
+		var err error
+		held := n.regions[msg.Host.ID]
+		switch msg.MessageType {
+		case "AddRegion":
+			held[msg.Region.UUID] = msg.Region
+		case "RemoveRegion":
+			delete(held, msg.Region.UUID)
+			if n.running[msg.Region.UUID] == msg.Host.ID {
+				delete(n.running, msg.Region.UUID)
+			}
+		case "StartRegion":
+			if _, ok := held[msg.Region.UUID]; !ok {
+				err = errors.New("Region is not present on this host")
+			} else {
+				n.running[msg.Region.UUID] = msg.Host.ID
+			}
+		case "StopRegion", "KillRegion":
+			delete(n.running, msg.Region.UUID)
+		}
+		n.mutex.Unlock()
+		if err != nil {
+			msg.response <- err
+			continue
+		}
+		close(msg.response)
+	}
+}
+
+// holds reports the region as a host has it, and whether the host is running it
+func (n testNode) holds(hostID int64, id uuid.UUID) (mgm.Region, bool, bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	r, ok := n.regions[hostID][id]
+	return r, ok, n.running[id] == hostID
+}
+
+// testGrid is a host manager with hosts 1 and 2 connected, and region r provisioned on host 1
+type testGrid struct {
+	m    Manager
+	node testNode
+	r    mgm.Region
+}
+
+func newTestGrid(running bool) testGrid {
+	hosts := map[int64]mgm.Host{
+		1: {ID: 1, Slots: 4, MinRegionPort: 9000, MaxRegionPort: 9010, MinConsolePort: 9100, MaxConsolePort: 9110, Builds: []string{"0.9.0", "0.9.1"}},
+		2: {ID: 2, Slots: 4, MinRegionPort: 9000, MaxRegionPort: 9010, MinConsolePort: 9100, MaxConsolePort: 9110, Builds: []string{"0.9.0", "0.9.1"}},
+	}
+	r := mgm.Region{UUID: uuid.NewV4(), Name: "Home", Size: 1, LocX: 1000, LocY: 1000, Host: 1, HTTPPort: 9000, ConsolePort: 9100, Version: "0.9.0"}
+	hosts[1] = withRegion(hosts[1], r.UUID)
+
+	state := mgm.RegionStopped
+	if running {
+		state = mgm.RegionReady
+	}
+	st := testStore{[]mgm.Region{r}, map[uuid.UUID]string{r.UUID: state}}
+	rMgr := region.NewManager("", "", st, persist.Database{}, testNotifier{}, testLog{})
+
+	m := Manager{
+		log:             testLog{},
+		rMgr:            rMgr,
+		notify:          testNotifier{},
+		hosts:           hosts,
+		draining:        make(map[int64]bool),
+		hMutex:          &sync.Mutex{},
+		hostConnections: map[int64]hostSession{1: {}, 2: {}},
+		hcMutex:         &sync.Mutex{},
+		hostStats:       map[int64]mgm.HostStat{1: {ID: 1, Running: true}, 2: {ID: 2, Running: true}},
+		hostSeen:        make(map[int64]time.Time),
+		hsMutex:         &sync.Mutex{},
+		policy:          leastLoaded{},
+		requestChan:     make(chan Message, 32),
+	}
+	node := testNode{
+		mutex:   &sync.Mutex{},
+		regions: map[int64]map[uuid.UUID]mgm.Region{1: {r.UUID: r}, 2: {}},
+		running: make(map[uuid.UUID]int64),
+		fail:    make(map[string]error),
+	}
+	if running {
+		node.running[r.UUID] = 1
+	}
+	go node.serve(m.requestChan)
+	return testGrid{m, node, r}
+}
+
+func withRegion(h mgm.Host, id uuid.UUID) mgm.Host {
+	h.Regions = append(h.Regions, id)
+	return h
+}
+
+// failNext makes the next request of a type to a host fail
+func (g testGrid) failNext(msgType string, hostID int64) {
+	g.node.mutex.Lock()
+	defer g.node.mutex.Unlock()
+	g.node.fail[fmt.Sprintf("%v %v", msgType, hostID)] = fmt.Errorf("%v refused", msgType)
+}
+
+// assertOn checks the region is assigned to and held by only hostID, running if it should be
+func (g testGrid) assertOn(t *testing.T, name string, hostID int64, running bool) {
+	r, ok := g.m.rMgr.GetRegion(g.r.UUID)
+	if !ok {
+		t.Fatalf("%v: region was lost", name)
+	}
+	if r.Host != hostID {
+		t.Errorf("%v: region assigned to host %v, want %v", name, r.Host, hostID)
+	}
+	if r.Version != g.r.Version {
+		t.Errorf("%v: region version %q, want %q", name, r.Version, g.r.Version)
+	}
+	for _, id := range []int64{1, 2} {
+		_, held, isRunning := g.node.holds(id, g.r.UUID)
+		if held != (id == hostID) {
+			t.Errorf("%v: host %v holds the region: %v", name, id, held)
+		}
+		if isRunning != (id == hostID && running) {
+			t.Errorf("%v: host %v runs the region: %v", name, id, isRunning)
+		}
+	}
+	want := mgm.RegionStopped
+	if running {
+		//the host has not reported the start yet
+		want = mgm.RegionStarting
+	}
+	if s := g.m.rMgr.GetRegionState(g.r.UUID); s != want {
+		t.Errorf("%v: region is %v, want %v", name, s, want)
+	}
+}
+
+func TestMigrateRegion(t *testing.T) {
+	tests := []struct {
+		name    string
+		running bool
+		fail    func(g testGrid)
+		moved   bool
+	}{
+		{"running region", true, func(testGrid) {}, true},
+		{"stopped region", false, func(testGrid) {}, true},
+		{"target has no free slots", true, func(g testGrid) {
+			g.m.hosts[2] = mgm.Host{ID: 2, MinRegionPort: 9000, MaxRegionPort: 9010, MinConsolePort: 9100, MaxConsolePort: 9110}
+		}, false},
+		{"target refuses the region", true, func(g testGrid) { g.failNext("AddRegion", 2) }, false},
+		{"target cannot start the region", true, func(g testGrid) { g.failNext("StartRegion", 2) }, false},
+		{"stopped region target refuses", false, func(g testGrid) { g.failNext("AddRegion", 2) }, false},
+		{"source cannot remove the region", true, func(g testGrid) { g.failNext("RemoveRegion", 1) }, false},
+		{"graceful stop refused", true, func(g testGrid) { g.failNext("StopRegion", 1) }, true},
+	}
+	for _, tt := range tests {
+		g := newTestGrid(tt.running)
+		tt.fail(g)
+		target, _ := g.m.GetHost(2)
+		err := g.m.MigrateRegion(g.r, target, "admin", false, func(string) {})
+		if tt.moved {
+			if err != nil {
+				t.Errorf("%v: %v", tt.name, err)
+			}
+			g.assertOn(t, tt.name, 2, tt.running)
+			continue
+		}
+		if err == nil {
+			t.Errorf("%v: migration succeeded", tt.name)
+		}
+		g.assertOn(t, tt.name, 1, tt.running)
+	}
+}
+
+func TestMigrateRegionFailedRestart(t *testing.T) {
+	g := newTestGrid(true)
+	g.failNext("RemoveRegion", 1)
+	g.failNext("StartRegion", 1)
+	target, _ := g.m.GetHost(2)
+	err := g.m.MigrateRegion(g.r, target, "admin", false, func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "restart failed") {
+		t.Errorf("got %v, want the failed restart reported", err)
+	}
+	if s := g.m.rMgr.GetRegionState(g.r.UUID); s != mgm.RegionStopped {
+		t.Errorf("region is %v, want stopped", s)
+	}
+}
+
+func TestMigrateRegionOfflineSource(t *testing.T) {
+	g := newTestGrid(false)
+	delete(g.m.hostConnections, 1)
+	target, _ := g.m.GetHost(2)
+
+	if err := g.m.MigrateRegion(g.r, target, "admin", false, func(string) {}); err == nil {
+		t.Error("region moved off of an offline host without force")
+	}
+	if r, _ := g.m.rMgr.GetRegion(g.r.UUID); r.Host != 1 {
+		t.Errorf("refused migration left the region on host %v", r.Host)
+	}
+
+	if err := g.m.MigrateRegion(g.r, target, "admin", true, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := g.m.rMgr.GetRegion(g.r.UUID); r.Host != 2 {
+		t.Errorf("forced migration left the region on host %v", r.Host)
+	}
+}
+
+func TestMigrateRegionWhileBusy(t *testing.T) {
+	g := newTestGrid(true)
+	if _, err := g.m.rMgr.RequestTransition(g.r.UUID, mgm.RegionStopping, "admin", "Stop requested"); err != nil {
+		t.Fatal(err)
+	}
+	target, _ := g.m.GetHost(2)
+	if err := g.m.MigrateRegion(g.r, target, "admin", false, func(string) {}); err == nil {
+		t.Error("a stopping region was migrated")
+	}
+	if r, _ := g.m.rMgr.GetRegion(g.r.UUID); r.Host != 1 {
+		t.Errorf("refused migration left the region on host %v", r.Host)
+	}
+}
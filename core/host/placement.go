@@ -0,0 +1,308 @@
+package host
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+)
+
+// Candidate is a host eligible to receive a region, with the load figures a policy may weigh
+type Candidate struct {
+	Host    mgm.Host
+	Stat    mgm.HostStat
+	Regions int
+}
+
+// CPUPercent averages the per-core cpu usage of the candidate
+func (c Candidate) CPUPercent() float64 {
+	if len(c.Stat.CPUPercent) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, p := range c.Stat.CPUPercent {
+		total += p
+	}
+	return total / float64(len(c.Stat.CPUPercent))
+}
+
+// String describes the candidate load, for explaining placement decisions
+func (c Candidate) String() string {
+	return fmt.Sprintf("%.0f%% mem, %.0f%% cpu, %v/%v slots", c.Stat.MEMPercent, c.CPUPercent(), c.Regions, c.Host.Slots)
+}
+
+// PlacementRequest describes the region being placed
+type PlacementRequest struct {
+	Region mgm.Region
+	Labels []string
+}
+
+// PlacementPolicy chooses which of the candidate hosts should receive a region
+type PlacementPolicy interface {
+	Name() string
+	Choose(req PlacementRequest, candidates []Candidate) (Candidate, error)
+}
+
+// Placement is the outcome of a placement decision
+type Placement struct {
+	Host   mgm.Host
+	Reason string
+}
+
+// NewPlacementPolicy constructs a placement policy by name, defaulting to least-loaded
+func NewPlacementPolicy(name string) (PlacementPolicy, error) {
+	switch name {
+	case "", "least-loaded":
+		return leastLoaded{}, nil
+	case "bin-packing":
+		return binPacking{}, nil
+	case "affinity":
+		return affinity{leastLoaded{}}, nil
+	case "affinity-bin-packing":
+		return affinity{binPacking{}}, nil
+	default:
+		return nil, fmt.Errorf("Unknown placement policy %v", name)
+	}
+}
+
+// leastLoaded spreads regions, choosing the host with the lowest memory then cpu usage
+type leastLoaded struct{}
+
+func (leastLoaded) Name() string {
+	return "least-loaded"
+}
+
+func (leastLoaded) Choose(req PlacementRequest, candidates []Candidate) (Candidate, error) {
+	if len(candidates) == 0 {
+		return Candidate{}, errors.New("No hosts available")
+	}
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.Stat.MEMPercent < best.Stat.MEMPercent ||
+			(c.Stat.MEMPercent == best.Stat.MEMPercent && c.CPUPercent() < best.CPUPercent()) {
+			best = c
+		}
+	}
+	return best, nil
+}
+
+// binPacking fills hosts, choosing the host with the fewest free slots remaining
+type binPacking struct{}
+
+func (binPacking) Name() string {
+	return "bin-packing"
+}
+
+func (binPacking) Choose(req PlacementRequest, candidates []Candidate) (Candidate, error) {
+	if len(candidates) == 0 {
+		return Candidate{}, errors.New("No hosts available")
+	}
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.Host.Slots-c.Regions < best.Host.Slots-best.Regions {
+			best = c
+		}
+	}
+	return best, nil
+}
+
+// affinity restricts placement to hosts carrying every label the region asks for, deferring to another policy
+type affinity struct {
+	fallback PlacementPolicy
+}
+
+func (a affinity) Name() string {
+	return "affinity/" + a.fallback.Name()
+}
+
+func (a affinity) Choose(req PlacementRequest, candidates []Candidate) (Candidate, error) {
+	if len(req.Labels) == 0 {
+		return a.fallback.Choose(req, candidates)
+	}
+	var matching []Candidate
+	for _, c := range candidates {
+		if hasLabels(c.Host, req.Labels) {
+			matching = append(matching, c)
+		}
+	}
+	if len(matching) == 0 {
+		return Candidate{}, fmt.Errorf("No hosts available with labels %v", strings.Join(req.Labels, ","))
+	}
+	return a.fallback.Choose(req, matching)
+}
+
+func hasBuild(h mgm.Host, build string) bool {
+	for _, b := range h.Builds {
+		if b == build {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLabels(h mgm.Host, labels []string) bool {
+	for _, l := range labels {
+		found := false
+		for _, hl := range h.Labels {
+			if hl == l {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// PlaceRegion selects a host for a region using the configured policy, excluding any listed hosts
+func (m Manager) PlaceRegion(r mgm.Region, exclude ...int64) (Placement, error) {
+	skip := make(map[int64]bool)
+	for _, id := range exclude {
+		skip[id] = true
+	}
+
+	regions := m.rMgr.GetRegions()
+
+	m.hMutex.Lock()
+	//hosts being drained take no new regions
+	for id := range m.draining {
+		skip[id] = true
+	}
+	m.hsMutex.Lock()
+	candidates := placementCandidates(r, m.hosts, m.hostStats, regions, skip)
+	m.hsMutex.Unlock()
+	m.hMutex.Unlock()
+
+	req := PlacementRequest{Region: r, Labels: m.rMgr.GetHostLabels(r.UUID)}
+	c, err := m.policy.Choose(req, candidates)
+	if err != nil {
+		return Placement{}, err
+	}
+
+	p := Placement{
+		Host:   c.Host,
+		Reason: fmt.Sprintf("host %v chosen by %v: %v", c.Host.ID, m.policy.Name(), c),
+	}
+	m.log.Info("Placing region %v: %v", r.UUID, p.Reason)
+	return p, nil
+}
+
+// placementCandidates lists the hosts able to take region r, with the number of regions each already holds
+func placementCandidates(r mgm.Region, hosts map[int64]mgm.Host, stats map[int64]mgm.HostStat, regions []mgm.Region, skip map[int64]bool) []Candidate {
+	counts := make(map[int64]int)
+	for _, reg := range regions {
+		if reg.UUID != r.UUID {
+			counts[reg.Host]++
+		}
+	}
+
+	var candidates []Candidate
+	for _, h := range hosts {
+		stat := stats[h.ID]
+		//only online hosts that have reported their capacity and have room can take regions
+		if skip[h.ID] || !stat.Running || h.MaxRegionPort == 0 || counts[h.ID] >= h.Slots {
+			continue
+		}
+		//a pinned region can only go where its build is installed
+		if r.Version != "" && !hasBuild(h, r.Version) {
+			continue
+		}
+		candidates = append(candidates, Candidate{h, stat, counts[h.ID]})
+	}
+	return candidates
+}
+
+// AutoAssignRegion places an unassigned region with the configured policy, and provisions it on the chosen host
+func (m Manager) AutoAssignRegion(r mgm.Region) (mgm.Region, Placement, error) {
+	var full []int64
+	var p Placement
+	var reg mgm.Region
+	for {
+		var err error
+		p, err = m.PlaceRegion(r, full...)
+		if err != nil {
+			return r, p, err
+		}
+		reg, err = m.AssignRegion(r.UUID, p.Host.ID)
+		if err == errNoSlots {
+			//another placement took the last slot first, choose again without that host
+			full = append(full, p.Host.ID)
+			continue
+		}
+		if err != nil {
+			return r, p, err
+		}
+		break
+	}
+	err := m.AddRegionToHost(reg, p.Host)
+	if err != nil {
+		//leave the region unassigned, rather than on a host that does not have it
+		m.UnassignRegion(r.UUID)
+		return r, p, err
+	}
+	return reg, p, nil
+}
+
+// DrainHost migrates every region off of a host on behalf of actor, placing each with the configured policy.
+// No regions are placed on the host while it drains.
+func (m Manager) DrainHost(id int64, actor string, report func(string)) error {
+	m.hMutex.Lock()
+	if _, ok := m.hosts[id]; !ok {
+		m.hMutex.Unlock()
+		return errors.New("Host does not exist")
+	}
+	if m.draining[id] {
+		m.hMutex.Unlock()
+		return errors.New("Host is already being drained")
+	}
+	m.draining[id] = true
+	m.hMutex.Unlock()
+	defer func() {
+		m.hMutex.Lock()
+		delete(m.draining, id)
+		m.hMutex.Unlock()
+	}()
+
+	var regions []mgm.Region
+	for _, r := range m.rMgr.GetRegions() {
+		if r.Host == id {
+			regions = append(regions, r)
+		}
+	}
+
+	for i, r := range regions {
+		full := []int64{id}
+		for {
+			//a rolled back migration may have returned the region on new ports
+			cur, ok := m.rMgr.GetRegion(r.UUID)
+			if !ok || cur.Host != id {
+				report(fmt.Sprintf("Region %v/%v %v: no longer on this host", i+1, len(regions), r.Name))
+				break
+			}
+			r = cur
+			p, err := m.PlaceRegion(r, full...)
+			if err != nil {
+				return fmt.Errorf("Cannot place region %v: %v", r.Name, err.Error())
+			}
+			report(fmt.Sprintf("Region %v/%v %v: %v", i+1, len(regions), r.Name, p.Reason))
+			err = m.MigrateRegion(r, p.Host, actor, false, func(status string) {
+				report(fmt.Sprintf("Region %v/%v %v: %v", i+1, len(regions), r.Name, status))
+			})
+			if err == errNoSlots {
+				//another placement took the last slot first, the region was rolled back, choose again without that host
+				full = append(full, p.Host.ID)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("Migration of region %v failed: %v", r.Name, err.Error())
+			}
+			break
+		}
+	}
+
+	report(fmt.Sprintf("Host drained of %v region(s)", len(regions)))
+	return nil
+}
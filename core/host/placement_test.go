@@ -0,0 +1,142 @@
+package host
+
+import (
+	"testing"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+func candidate(id int64, mem float64, cpu float64, regions int, slots int, labels ...string) Candidate {
+	return Candidate{
+		Host:    mgm.Host{ID: id, Slots: slots, Labels: labels},
+		Stat:    mgm.HostStat{MEMPercent: mem, CPUPercent: []float64{cpu}},
+		Regions: regions,
+	}
+}
+
+func TestPlacementPolicies(t *testing.T) {
+	quiet := candidate(1, 20, 50, 1, 10, "ssd")
+	busy := candidate(2, 60, 10, 8, 10, "ssd", "eu")
+	idle := candidate(3, 20, 10, 4, 10, "eu")
+
+	tests := []struct {
+		name       string
+		policy     string
+		labels     []string
+		candidates []Candidate
+		want       int64
+		fail       bool
+	}{
+		{"least-loaded without hosts", "least-loaded", nil, nil, 0, true},
+		{"least-loaded prefers memory", "least-loaded", nil, []Candidate{busy, quiet}, 1, false},
+		{"least-loaded breaks ties on cpu", "least-loaded", nil, []Candidate{quiet, busy, idle}, 3, false},
+		{"bin-packing without hosts", "bin-packing", nil, nil, 0, true},
+		{"bin-packing fills the fullest host", "bin-packing", nil, []Candidate{quiet, busy, idle}, 2, false},
+		{"affinity without labels defers", "affinity", nil, []Candidate{busy, quiet}, 1, false},
+		{"affinity restricts to labelled hosts", "affinity", []string{"eu"}, []Candidate{quiet, busy, idle}, 3, false},
+		{"affinity requires every label", "affinity", []string{"ssd", "eu"}, []Candidate{quiet, busy, idle}, 2, false},
+		{"affinity without a match", "affinity", []string{"gpu"}, []Candidate{quiet, busy, idle}, 0, true},
+		{"affinity bin-packing", "affinity-bin-packing", []string{"eu"}, []Candidate{quiet, idle, busy}, 2, false},
+	}
+	for _, tt := range tests {
+		p, err := NewPlacementPolicy(tt.policy)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		c, err := p.Choose(PlacementRequest{Labels: tt.labels}, tt.candidates)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%v: chose host %v, want an error", tt.name, c.Host.ID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tt.name, err)
+			continue
+		}
+		if c.Host.ID != tt.want {
+			t.Errorf("%v: chose host %v, want %v", tt.name, c.Host.ID, tt.want)
+		}
+	}
+
+	if _, err := NewPlacementPolicy("random"); err == nil {
+		t.Error("unknown policy was accepted")
+	}
+}
+
+func TestHasLabels(t *testing.T) {
+	h := mgm.Host{Labels: []string{"ssd", "eu"}}
+	tests := []struct {
+		labels []string
+		want   bool
+	}{
+		{nil, true},
+		{[]string{"ssd"}, true},
+		{[]string{"eu", "ssd"}, true},
+		{[]string{"ssd", "gpu"}, false},
+		{[]string{"SSD"}, false},
+	}
+	for _, tt := range tests {
+		if got := hasLabels(h, tt.labels); got != tt.want {
+			t.Errorf("hasLabels(%v) = %v, want %v", tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestPlacementCandidates(t *testing.T) {
+	online := mgm.HostStat{Running: true}
+	hosts := map[int64]mgm.Host{
+		1: {ID: 1, Slots: 2, MaxRegionPort: 9010},
+		2: {ID: 2, Slots: 1, MaxRegionPort: 9010},
+		3: {ID: 3, Slots: 4, MaxRegionPort: 9010, Builds: []string{"0.9.1"}},
+		4: {ID: 4, Slots: 4},
+		5: {ID: 5, Slots: 4, MaxRegionPort: 9010},
+	}
+	stats := map[int64]mgm.HostStat{1: online, 2: online, 3: online, 4: online}
+	r := mgm.Region{UUID: uuid.NewV4()}
+	regions := []mgm.Region{r, {UUID: uuid.NewV4(), Host: 1}, {UUID: uuid.NewV4(), Host: 2}}
+	pinned := mgm.Region{UUID: uuid.NewV4(), Version: "0.9.1"}
+	onFull := mgm.Region{UUID: regions[2].UUID, Host: 2}
+
+	tests := []struct {
+		name string
+		r    mgm.Region
+		skip map[int64]bool
+		want []int64
+	}{
+		{"new region skips full, portless and offline hosts", r, nil, []int64{1, 3}},
+		{"excluded hosts are skipped", r, map[int64]bool{1: true}, []int64{3}},
+		{"a pinned region needs its build", pinned, nil, []int64{3}},
+		{"a region does not count against its own host", onFull, nil, []int64{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		got := map[int64]bool{}
+		for _, c := range placementCandidates(tt.r, hosts, stats, regions, tt.skip) {
+			got[c.Host.ID] = true
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%v: got hosts %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for _, id := range tt.want {
+			if !got[id] {
+				t.Errorf("%v: got hosts %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestRegionsOn(t *testing.T) {
+	r := mgm.Region{UUID: uuid.NewV4(), Host: 1}
+	regions := []mgm.Region{r, {UUID: uuid.NewV4(), Host: 1}, {UUID: uuid.NewV4(), Host: 2}, {UUID: uuid.NewV4(), Host: 1}}
+	if n := regionsOn(1, r, regions); n != 2 {
+		t.Errorf("regions on host 1 besides r: got %v, want 2", n)
+	}
+	if n := regionsOn(1, mgm.Region{UUID: uuid.NewV4()}, regions); n != 3 {
+		t.Errorf("regions on host 1 for a new region: got %v, want 3", n)
+	}
+	if n := regionsOn(3, r, regions); n != 0 {
+		t.Errorf("regions on an empty host: got %v, want 0", n)
+	}
+}
@@ -0,0 +1,83 @@
+package host
+
+import "fmt"
+
+// ProtocolVersion is the MGM<->node protocol version spoken by this build
+const ProtocolVersion = 1
+
+// MinProtocolVersion is the oldest protocol version this build can still speak
+const MinProtocolVersion = 1
+
+// Optional features a peer may advertise during registration
+const (
+	CapRegionControl   = "RegionControl"
+	CapRegionInventory = "RegionInventory"
+	CapRegionStop      = "RegionStop"
+	CapRegionEvents    = "RegionEvents"
+	CapRegionLogs      = "RegionLogs"
+	CapRegionConsole   = "RegionConsole"
+)
+
+// Capabilities lists the optional features implemented by this build
+var Capabilities = []string{
+	CapRegionControl,
+	CapRegionInventory,
+	CapRegionStop,
+	CapRegionEvents,
+	CapRegionLogs,
+	CapRegionConsole,
+}
+
+// requiredCapability maps MGM requests to the capability a node must advertise to receive them
+var requiredCapability = map[string]string{
+	"AddRegion":    CapRegionControl,
+	"RemoveRegion": CapRegionControl,
+	"StartRegion":  CapRegionControl,
+	"KillRegion":   CapRegionControl,
+	"StopRegion":   CapRegionStop,
+	"GetRegionLog": CapRegionLogs,
+	"Console":      CapRegionConsole,
+}
+
+// concurrentRequests may be pending several times over for the same region
+var concurrentRequests = map[string]bool{
+	"Console": true,
+}
+
+// NewRegistration constructs a Registration describing this build
+func NewRegistration() Registration {
+	return Registration{
+		ProtocolVersion:    ProtocolVersion,
+		MinProtocolVersion: MinProtocolVersion,
+		Capabilities:       Capabilities,
+	}
+}
+
+// Negotiate selects the protocol version spoken with a peer, failing if the version ranges do not overlap
+func Negotiate(peer Registration) (int, error) {
+	return negotiate(NewRegistration(), peer)
+}
+
+// negotiate selects the newest protocol version within both the local and peer version ranges
+func negotiate(local Registration, peer Registration) (int, error) {
+	if peer.ProtocolVersion < local.MinProtocolVersion {
+		return 0, fmt.Errorf("Peer speaks protocol version %v, version %v or newer is required", peer.ProtocolVersion, local.MinProtocolVersion)
+	}
+	if peer.MinProtocolVersion > local.ProtocolVersion {
+		return 0, fmt.Errorf("Peer requires protocol version %v or newer, this build speaks version %v", peer.MinProtocolVersion, local.ProtocolVersion)
+	}
+	if peer.ProtocolVersion < local.ProtocolVersion {
+		return peer.ProtocolVersion, nil
+	}
+	return local.ProtocolVersion, nil
+}
+
+// HasCapability tests if a registration advertises a specific capability
+func (r Registration) HasCapability(capability string) bool {
+	for _, c := range r.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package host
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	versions := func(min int, max int) Registration {
+		return Registration{MinProtocolVersion: min, ProtocolVersion: max}
+	}
+
+	tests := []struct {
+		name  string
+		local Registration
+		peer  Registration
+		want  int
+		fail  bool
+	}{
+		{"identical", versions(1, 1), versions(1, 1), 1, false},
+		{"peer is newer", versions(1, 2), versions(1, 3), 2, false},
+		{"peer is older", versions(1, 3), versions(1, 2), 2, false},
+		{"overlap at the top of the peer range", versions(2, 4), versions(1, 2), 2, false},
+		{"overlap at the bottom of the peer range", versions(1, 2), versions(2, 4), 2, false},
+		{"peer too old", versions(2, 3), versions(1, 1), 0, true},
+		{"peer too new", versions(1, 2), versions(3, 4), 0, true},
+		{"peer without a minimum", versions(1, 2), versions(0, 1), 1, false},
+	}
+	for _, tt := range tests {
+		got, err := negotiate(tt.local, tt.peer)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%v: negotiated version %v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%v: negotiated version %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	//this build must always be able to talk to itself
+	if _, err := Negotiate(NewRegistration()); err != nil {
+		t.Errorf("this build rejected its own registration: %v", err)
+	}
+}
+
+func TestHasCapability(t *testing.T) {
+	r := Registration{Capabilities: []string{CapRegionControl, CapRegionLogs}}
+	if !r.HasCapability(CapRegionLogs) {
+		t.Error("advertised capability was not found")
+	}
+	if r.HasCapability(CapRegionConsole) {
+		t.Error("capability that was not advertised was found")
+	}
+	if (Registration{}).HasCapability(CapRegionControl) {
+		t.Error("a registration without capabilities reported one")
+	}
+}
@@ -0,0 +1,45 @@
+package host
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+)
+
+// SetRegionVersion pins a region to an opensim build on behalf of actor, or to the host default when version
+// is empty.  A region already on a connected host is re-provisioned from the new build, and must not be running.
+func (m Manager) SetRegionVersion(r mgm.Region, version string, actor string) (mgm.Region, error) {
+	//re-provisioning kills the process, a region just asked to start has not reported running yet
+	if !m.rMgr.IsHalted(r.UUID) {
+		return r, errors.New("Region must be stopped before changing its version")
+	}
+
+	h, assigned := m.GetHost(r.Host)
+	if assigned && version != "" && !hasBuild(h, version) {
+		return r, fmt.Errorf("Host %v does not have build %v", h.ID, version)
+	}
+
+	previous := r.Version
+	r.Version = version
+	m.rMgr.UpdateRegion(r)
+	m.log.Info("Region %v pinned to build %q by %v", r.UUID, version, actor)
+
+	if !assigned || !m.isConnected(h.ID) {
+		//provisioned from the new build when it next reaches a host
+		return r, nil
+	}
+
+	err := m.RemoveRegionFromHost(r, h)
+	if err == nil {
+		err = m.AddRegionToHost(r, h)
+	}
+	if err != nil {
+		//put the region back on the build it had
+		r.Version = previous
+		m.rMgr.UpdateRegion(r)
+		m.AddRegionToHost(r, h)
+		return r, err
+	}
+	return r, nil
+}
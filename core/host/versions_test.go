@@ -0,0 +1,51 @@
+package host
+
+import (
+	"testing"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+)
+
+func TestSetRegionVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		running bool
+		prepare func(g testGrid)
+		version string
+		changed bool
+	}{
+		{"stopped region", false, func(testGrid) {}, "0.9.1", true},
+		{"running region", true, func(testGrid) {}, "0.9.1", false},
+		{"region asked to start", false, func(g testGrid) {
+			g.m.rMgr.RequestTransition(g.r.UUID, mgm.RegionStarting, "admin", "Start requested")
+		}, "0.9.1", false},
+		{"build missing from host", false, func(testGrid) {}, "0.8.0", false},
+		{"host cannot remove the region", false, func(g testGrid) { g.failNext("RemoveRegion", 1) }, "0.9.1", false},
+		{"host cannot add the region", false, func(g testGrid) { g.failNext("AddRegion", 1) }, "0.9.1", false},
+	}
+	for _, tt := range tests {
+		g := newTestGrid(tt.running)
+		tt.prepare(g)
+		_, err := g.m.SetRegionVersion(g.r, tt.version, "admin")
+		if tt.changed && err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+		}
+		if !tt.changed && err == nil {
+			t.Errorf("%v: version was changed", tt.name)
+		}
+
+		want := g.r.Version
+		if tt.changed {
+			want = tt.version
+		}
+		if r, _ := g.m.rMgr.GetRegion(g.r.UUID); r.Version != want || r.Host != 1 {
+			t.Errorf("%v: region on host %v with version %q, want host 1 with %q", tt.name, r.Host, r.Version, want)
+		}
+		held, ok, _ := g.node.holds(1, g.r.UUID)
+		if !ok {
+			t.Errorf("%v: host no longer holds the region", tt.name)
+		} else if held.Version != want {
+			t.Errorf("%v: host provisioned version %q, want %q", tt.name, held.Version, want)
+		}
+	}
+}
@@ -25,6 +25,7 @@ func (jm Manager) newRegionCommand() regionCommand {
 }
 
 type notifier interface {
+	JobUpdated(mgm.Job)
 }
 
 // NewManager constructs a jobManager for use
@@ -86,6 +87,32 @@ func (jm Manager) GetJobByID(id int64) (mgm.Job, bool) {
 	return t, ok
 }
 
+// UpdateJob caches and persists a modified job, notifying the client manager as well
+func (jm Manager) UpdateJob(j mgm.Job) {
+	jm.jMutex.Lock()
+	jm.jobs[j.ID] = j
+	jm.jMutex.Unlock()
+	jm.mgm.UpdateJob(j)
+	jm.notify.JobUpdated(j)
+}
+
+// UpdateJobStatus replaces the Status field common to all job data, leaving the rest intact
+func (jm Manager) UpdateJobStatus(id int64, status string) {
+	j, ok := jm.GetJobByID(id)
+	if !ok {
+		jm.log.Error("Cannot update status of job %v, it does not exist", id)
+		return
+	}
+
+	data := make(map[string]interface{})
+	json.Unmarshal([]byte(j.Data), &data)
+	data["Status"] = status
+	encDat, _ := json.Marshal(data)
+	j.Data = string(encDat)
+
+	jm.UpdateJob(j)
+}
+
 // DeleteJob purges a job from the cache and database
 func (jm Manager) DeleteJob(j mgm.Job) {
 	jm.jMutex.Lock()
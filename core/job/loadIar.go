@@ -35,7 +35,19 @@ func (jm Manager) CreateLoadIarJob(owner mgm.User, inventoryPath string, filenam
 
 // AddJob place a job into the cache and persist it
 func (jm Manager) AddJob(j mgm.Job) int64 {
-	return 0
+	id, err := jm.mgm.InsertJob(j)
+	if err != nil {
+		jm.log.Error("Error inserting job: %v", err.Error())
+		return 0
+	}
+	j.ID = id
+
+	jm.jMutex.Lock()
+	jm.jobs[id] = j
+	jm.jMutex.Unlock()
+
+	jm.notify.JobUpdated(j)
+	return id
 }
 
 //loadIarTask is a coroutine that manages and reports on loading an iar file
@@ -0,0 +1,84 @@
+package job
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+// migrateRegionJob is the data field for jobs that are of type migrate_region
+type migrateRegionJob struct {
+	Region uuid.UUID
+	From   int64
+	To     int64
+	Status string
+}
+
+// CreateMigrateRegionJob utility function to create job of type migrate_region
+func (jm Manager) CreateMigrateRegionJob(owner mgm.User, r mgm.Region, to mgm.Host) int64 {
+	j := mgm.Job{}
+	j.Type = "migrate_region"
+	j.Timestamp = time.Now()
+	j.User = owner.UserID
+
+	jd := migrateRegionJob{}
+	jd.Region = r.UUID
+	jd.From = r.Host
+	jd.To = to.ID
+	jd.Status = "Created"
+
+	encDat, _ := json.Marshal(jd)
+	j.Data = string(encDat)
+
+	return jm.AddJob(j)
+}
+
+// drainHostJob is the data field for jobs that are of type drain_host
+type drainHostJob struct {
+	Host   int64
+	Status string
+}
+
+// CreateDrainHostJob utility function to create job of type drain_host
+func (jm Manager) CreateDrainHostJob(owner mgm.User, h mgm.Host) int64 {
+	j := mgm.Job{}
+	j.Type = "drain_host"
+	j.Timestamp = time.Now()
+	j.User = owner.UserID
+
+	jd := drainHostJob{}
+	jd.Host = h.ID
+	jd.Status = "Created"
+
+	encDat, _ := json.Marshal(jd)
+	j.Data = string(encDat)
+
+	return jm.AddJob(j)
+}
+
+// stopRegionJob is the data field for jobs that are of type stop_region
+type stopRegionJob struct {
+	Region uuid.UUID
+	Host   int64
+	Status string
+}
+
+// CreateStopRegionJob utility function to create job of type stop_region
+func (jm Manager) CreateStopRegionJob(owner mgm.User, r mgm.Region) int64 {
+	j := mgm.Job{}
+	j.Type = "stop_region"
+	j.Timestamp = time.Now()
+	j.User = owner.UserID
+
+	jd := stopRegionJob{}
+	jd.Region = r.UUID
+	jd.Host = r.Host
+	jd.Status = "Created"
+
+	encDat, _ := json.Marshal(jd)
+	j.Data = string(encDat)
+
+	return jm.AddJob(j)
+}
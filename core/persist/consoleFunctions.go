@@ -0,0 +1,26 @@
+package persist
+
+import "github.com/m-o-s-e-s/mgm/mgm"
+
+// RecordConsoleCommand appends a console command to the audit trail.
+// It expects a table created as below, which UpgradeSchema creates when missing:
+//
+//	CREATE TABLE consoleAudit (id BIGINT AUTO_INCREMENT PRIMARY KEY, region VARCHAR(36), user VARCHAR(36),
+//	  name VARCHAR(64), command TEXT, allowed BOOL, reason TEXT, ts BIGINT)
+func (m MGMDB) RecordConsoleCommand(a mgm.ConsoleAudit) error {
+	con, err := m.db.getConnection()
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	_, err = con.Exec("INSERT INTO consoleAudit (region, user, name, command, allowed, reason, ts) VALUES (?,?,?,?,?,?,?)",
+		a.Region.String(),
+		a.User.String(),
+		a.Name,
+		a.Command,
+		a.Allowed,
+		a.Reason,
+		a.Timestamp.Unix())
+	return err
+}
@@ -7,8 +7,8 @@ import (
 	"github.com/m-o-s-e-s/mgm/mgm"
 )
 
-// InsertHost creates a new host record by address, returning the row id
-func (m MGMDB) InsertHost(address string) (int64, error) {
+// InsertHost creates a new host record by address and enrollment secret, returning the row id
+func (m MGMDB) InsertHost(address string, secret string) (int64, error) {
 	con, err := m.db.getConnection()
 	var id int64
 	if err != nil {
@@ -16,8 +16,8 @@ func (m MGMDB) InsertHost(address string) (int64, error) {
 	}
 	defer con.Close()
 
-	res, err := con.Exec("INSERT INTO hosts (address) VALUES (?)",
-		address)
+	res, err := con.Exec("INSERT INTO hosts (address, secret) VALUES (?,?)",
+		address, secret)
 	if err != nil {
 		return 0, err
 	}
@@ -28,11 +28,15 @@ func (m MGMDB) InsertHost(address string) (int64, error) {
 	return id, nil
 }
 
-func (m MGMDB) persistHost(host mgm.Host) {
+// UpdateHost persists the node-supplied fields of a host record
+func (m MGMDB) UpdateHost(host mgm.Host) {
 	con, err := m.db.getConnection()
 	if err == nil {
-		_, err = con.Exec("UPDATE hosts SET externalAddress=?, name=?, slots=? WHERE id=?",
-			host.ExternalAddress, host.Hostname, host.Slots, host.ID)
+		defer con.Close()
+		_, err = con.Exec("UPDATE hosts SET externalAddress=?, name=?, slots=?, minRegionPort=?, maxRegionPort=?, minConsolePort=?, maxConsolePort=? WHERE id=?",
+			host.ExternalAddress, host.Hostname, host.Slots,
+			host.MinRegionPort, host.MaxRegionPort, host.MinConsolePort, host.MaxConsolePort,
+			host.ID)
 	}
 	if err != nil {
 		errMsg := fmt.Sprintf("Error persisting host record: %v", err.Error())
@@ -40,6 +44,18 @@ func (m MGMDB) persistHost(host mgm.Host) {
 	}
 }
 
+// UpdateHostSecret replaces the enrollment secret of a host record
+func (m MGMDB) UpdateHostSecret(host int64, secret string) error {
+	con, err := m.db.getConnection()
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	_, err = con.Exec("UPDATE hosts SET secret=? WHERE id=?", secret, host)
+	return err
+}
+
 // PurgeHost removes a host record from the database
 func (m MGMDB) PurgeHost(host int64) {
 	con, err := m.db.getConnection()
@@ -62,7 +78,8 @@ func (m MGMDB) QueryHosts() []mgm.Host {
 		return hosts
 	}
 	defer con.Close()
-	rows, err := con.Query("Select id, address, externalAddress, name, slots from hosts")
+	rows, err := con.Query("Select id, address, externalAddress, name, slots, IFNULL(secret, ''), " +
+		"IFNULL(minRegionPort, 0), IFNULL(maxRegionPort, 0), IFNULL(minConsolePort, 0), IFNULL(maxConsolePort, 0) from hosts")
 	if err != nil {
 		errMsg := fmt.Sprintf("Error reading hosts: %v", err.Error())
 		m.log.Error(errMsg)
@@ -77,6 +94,11 @@ func (m MGMDB) QueryHosts() []mgm.Host {
 			&h.ExternalAddress,
 			&h.Hostname,
 			&h.Slots,
+			&h.Secret,
+			&h.MinRegionPort,
+			&h.MaxRegionPort,
+			&h.MinConsolePort,
+			&h.MaxConsolePort,
 		)
 		if err != nil {
 			errMsg := fmt.Sprintf("Error reading hosts: %v", err.Error())
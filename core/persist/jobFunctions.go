@@ -7,8 +7,8 @@ import (
 	"github.com/m-o-s-e-s/mgm/mgm"
 )
 
-// hosts are created by clients inserting an ip address, that is all we can insert
-func (m MGMDB) insertJob(job mgm.Job) (int64, error) {
+// InsertJob creates a new job record, returning the row id
+func (m MGMDB) InsertJob(job mgm.Job) (int64, error) {
 	con, err := m.db.getConnection()
 	var id int64
 	if err != nil {
@@ -28,7 +28,8 @@ func (m MGMDB) insertJob(job mgm.Job) (int64, error) {
 	return id, nil
 }
 
-func (m MGMDB) persistJob(job mgm.Job) {
+// UpdateJob persists the data field of a job record
+func (m MGMDB) UpdateJob(job mgm.Job) {
 	con, err := m.db.getConnection()
 	if err == nil {
 		_, err = con.Exec("UPDATE jobs SET data=? WHERE id=?",
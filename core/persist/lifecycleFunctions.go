@@ -0,0 +1,106 @@
+package persist
+
+import (
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+// RecordRegionTransition appends a region lifecycle transition to the event history.
+// It expects a table created as below, which UpgradeSchema creates when missing:
+//
+//	CREATE TABLE regionEvents (id BIGINT AUTO_INCREMENT PRIMARY KEY, region VARCHAR(36), host BIGINT,
+//	  fromState VARCHAR(16), toState VARCHAR(16), actor VARCHAR(64), reason TEXT, ts BIGINT,
+//	  INDEX (region, ts), INDEX (host, ts))
+//
+// Timestamps are in Unix seconds, as in the other history tables, transitions within a second keep their id order.
+func (m MGMDB) RecordRegionTransition(t mgm.RegionTransition) error {
+	con, err := m.db.getConnection()
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	_, err = con.Exec("INSERT INTO regionEvents (region, host, fromState, toState, actor, reason, ts) VALUES (?,?,?,?,?,?,?)",
+		t.Region.String(),
+		t.Host,
+		t.From,
+		t.To,
+		t.Actor,
+		t.Reason,
+		t.Timestamp.Unix())
+	return err
+}
+
+// QueryLastRegionStates reads the most recent lifecycle state recorded for every region
+func (m MGMDB) QueryLastRegionStates() (map[uuid.UUID]string, error) {
+	states := make(map[uuid.UUID]string)
+	con, err := m.db.getConnection()
+	if err != nil {
+		return states, err
+	}
+	defer con.Close()
+
+	rows, err := con.Query("SELECT region, toState FROM regionEvents WHERE id IN (SELECT MAX(id) FROM regionEvents GROUP BY region)")
+	if err != nil {
+		return states, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var region, state string
+		err = rows.Scan(&region, &state)
+		if err != nil {
+			return states, err
+		}
+		id, err := uuid.FromString(region)
+		if err != nil {
+			continue
+		}
+		states[id] = state
+	}
+	return states, rows.Err()
+}
+
+// QueryRegionTransitions reads the transitions of a region between from and until, in order.
+// The last transition before from is included, as it holds the state the period began in.
+func (m MGMDB) QueryRegionTransitions(region uuid.UUID, from time.Time, until time.Time) ([]mgm.RegionTransition, error) {
+	return m.queryTransitions("region=?", region.String(), from, until)
+}
+
+// QueryHostTransitions reads the transitions of every region on a host between from and until, in order.
+// The last transition of each region before from is included, as it holds the state the period began in.
+func (m MGMDB) QueryHostTransitions(host int64, from time.Time, until time.Time) ([]mgm.RegionTransition, error) {
+	return m.queryTransitions("host=?", host, from, until)
+}
+
+func (m MGMDB) queryTransitions(match string, subject interface{}, from time.Time, until time.Time) ([]mgm.RegionTransition, error) {
+	transitions := []mgm.RegionTransition{}
+	con, err := m.db.getConnection()
+	if err != nil {
+		return transitions, err
+	}
+	defer con.Close()
+
+	rows, err := con.Query("SELECT region, host, fromState, toState, actor, reason, ts FROM regionEvents "+
+		"WHERE "+match+" AND ts<? AND (ts>=? OR id IN "+
+		"(SELECT MAX(id) FROM regionEvents WHERE "+match+" AND ts<? GROUP BY region)) ORDER BY ts, id",
+		subject, until.Unix(), from.Unix(), subject, from.Unix())
+	if err != nil {
+		return transitions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t := mgm.RegionTransition{}
+		var region string
+		var ts int64
+		err = rows.Scan(&region, &t.Host, &t.From, &t.To, &t.Actor, &t.Reason, &ts)
+		if err != nil {
+			return transitions, err
+		}
+		t.Region, _ = uuid.FromString(region)
+		t.Timestamp = time.Unix(ts, 0)
+		transitions = append(transitions, t)
+	}
+	return transitions, rows.Err()
+}
@@ -0,0 +1,216 @@
+package persist
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+)
+
+// Metric subjects
+const (
+	MetricHost   = "host"
+	MetricRegion = "region"
+)
+
+// metric rollup resolutions, and how long each is retained
+var metricRetention = map[time.Duration]time.Duration{
+	time.Minute:    2 * 24 * time.Hour,
+	time.Hour:      30 * 24 * time.Hour,
+	24 * time.Hour: 365 * 24 * time.Hour,
+}
+
+type metricSample struct {
+	subject string
+	id      string
+	point   mgm.MetricPoint
+}
+
+// metricBuckets accumulate samples per host or region until they are averaged into a minute
+type metricBuckets map[string]*metricSample
+
+func (mb metricBuckets) add(s metricSample) {
+	key := s.subject + "/" + s.id
+	b, ok := mb[key]
+	if !ok {
+		b = &metricSample{subject: s.subject, id: s.id}
+		mb[key] = b
+	}
+	b.point.CPUPercent += s.point.CPUPercent
+	b.point.Memory += s.point.Memory
+	b.point.Agents += s.point.Agents
+	b.point.SimFPS += s.point.SimFPS
+	b.point.Samples++
+}
+
+// average reduces every bucket to its mean, timestamped with the start of the minute
+func (mb metricBuckets) average(start time.Time) []metricSample {
+	var samples []metricSample
+	for _, b := range mb {
+		n := float64(b.point.Samples)
+		b.point.CPUPercent /= n
+		b.point.Memory /= n
+		b.point.Agents /= n
+		b.point.SimFPS /= n
+		b.point.Time = start
+		samples = append(samples, *b)
+	}
+	return samples
+}
+
+// rollupStart is the timestamp of the rollup at a resolution that a point falls in
+func rollupStart(t time.Time, resolution time.Duration) int64 {
+	return t.Truncate(resolution).Unix()
+}
+
+// RecordHostStat queues a host sample for the metrics history
+func (m MGMDB) RecordHostStat(hs mgm.HostStat) {
+	if !hs.Running {
+		return
+	}
+	cpu := 0.0
+	for _, c := range hs.CPUPercent {
+		cpu += c
+	}
+	if len(hs.CPUPercent) > 0 {
+		cpu /= float64(len(hs.CPUPercent))
+	}
+	m.queueMetric(metricSample{MetricHost, fmt.Sprint(hs.ID), mgm.MetricPoint{
+		CPUPercent: cpu,
+		Memory:     hs.MEMPercent,
+	}})
+}
+
+// RecordRegionStat queues a region sample for the metrics history
+func (m MGMDB) RecordRegionStat(rs mgm.RegionStat) {
+	if !rs.Running {
+		return
+	}
+	m.queueMetric(metricSample{MetricRegion, rs.UUID.String(), mgm.MetricPoint{
+		CPUPercent: rs.CPUPercent,
+		Memory:     rs.MemKB,
+		Agents:     float64(rs.Sim.RootAgents),
+		SimFPS:     rs.Sim.SimFPS,
+	}})
+}
+
+func (m MGMDB) queueMetric(s metricSample) {
+	//history is best effort, never stall a stats update on it
+	select {
+	case m.metrics <- s:
+	default:
+	}
+}
+
+// recordMetrics averages samples into one minute buckets, and folds each bucket into every rollup.
+// It expects a table created as below, which UpgradeSchema creates when missing:
+//
+//	CREATE TABLE metrics (subject VARCHAR(16), id VARCHAR(36), resolution INT, ts BIGINT,
+//	  cpu DOUBLE, memory DOUBLE, agents DOUBLE, simFPS DOUBLE, samples INT,
+//	  PRIMARY KEY (subject, id, resolution, ts))
+func (m MGMDB) recordMetrics() {
+	buckets := make(metricBuckets)
+	flush := time.NewTicker(time.Minute)
+	prune := time.NewTicker(time.Hour)
+
+	for {
+		select {
+		case s := <-m.metrics:
+			buckets.add(s)
+		case now := <-flush.C:
+			if len(buckets) == 0 {
+				continue
+			}
+			samples := buckets.average(now.Add(-time.Minute).Truncate(time.Minute))
+			buckets = make(metricBuckets)
+			go m.persistMetrics(samples)
+		case now := <-prune.C:
+			go m.pruneMetrics(now)
+		}
+	}
+}
+
+func (m MGMDB) persistMetrics(samples []metricSample) {
+	con, err := m.db.getConnection()
+	if err != nil {
+		m.log.Error("Error connecting to database: %v", err.Error())
+		return
+	}
+	defer con.Close()
+
+	for _, s := range samples {
+		for resolution := range metricRetention {
+			//merge into the rollup as a running average, weighted by sample count
+			_, err = con.Exec("INSERT INTO metrics (subject, id, resolution, ts, cpu, memory, agents, simFPS, samples) "+
+				"VALUES (?,?,?,?,?,?,?,?,?) ON DUPLICATE KEY UPDATE "+
+				"cpu=(cpu*samples+VALUES(cpu)*VALUES(samples))/(samples+VALUES(samples)), "+
+				"memory=(memory*samples+VALUES(memory)*VALUES(samples))/(samples+VALUES(samples)), "+
+				"agents=(agents*samples+VALUES(agents)*VALUES(samples))/(samples+VALUES(samples)), "+
+				"simFPS=(simFPS*samples+VALUES(simFPS)*VALUES(samples))/(samples+VALUES(samples)), "+
+				"samples=samples+VALUES(samples)",
+				s.subject,
+				s.id,
+				int64(resolution/time.Second),
+				rollupStart(s.point.Time, resolution),
+				s.point.CPUPercent,
+				s.point.Memory,
+				s.point.Agents,
+				s.point.SimFPS,
+				s.point.Samples)
+			if err != nil {
+				m.log.Error("Error recording metrics: %v", err.Error())
+				return
+			}
+		}
+	}
+}
+
+func (m MGMDB) pruneMetrics(now time.Time) {
+	con, err := m.db.getConnection()
+	if err != nil {
+		m.log.Error("Error connecting to database: %v", err.Error())
+		return
+	}
+	defer con.Close()
+
+	for resolution, retention := range metricRetention {
+		_, err = con.Exec("DELETE FROM metrics WHERE resolution=? AND ts<?",
+			int64(resolution/time.Second), now.Add(-retention).Unix())
+		if err != nil {
+			m.log.Error("Error pruning metrics: %v", err.Error())
+		}
+	}
+}
+
+// QueryMetrics reads the metrics history of a host or region at a rollup resolution, from since until now
+func (m MGMDB) QueryMetrics(subject string, id string, resolution time.Duration, since time.Time) ([]mgm.MetricPoint, error) {
+	points := []mgm.MetricPoint{}
+	if _, ok := metricRetention[resolution]; !ok {
+		return points, fmt.Errorf("Unsupported metrics resolution %v", resolution)
+	}
+
+	con, err := m.db.getConnection()
+	if err != nil {
+		return points, err
+	}
+	defer con.Close()
+
+	rows, err := con.Query("SELECT ts, cpu, memory, agents, simFPS, samples FROM metrics "+
+		"WHERE subject=? AND id=? AND resolution=? AND ts>=? ORDER BY ts",
+		subject, id, int64(resolution/time.Second), since.Unix())
+	if err != nil {
+		return points, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := mgm.MetricPoint{}
+		var ts int64
+		err = rows.Scan(&ts, &p.CPUPercent, &p.Memory, &p.Agents, &p.SimFPS, &p.Samples)
+		if err != nil {
+			return points, err
+		}
+		p.Time = time.Unix(ts, 0)
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
@@ -0,0 +1,107 @@
+package persist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+func TestMetricBuckets(t *testing.T) {
+	region := uuid.NewV4().String()
+	mb := make(metricBuckets)
+	mb.add(metricSample{MetricRegion, region, mgm.MetricPoint{CPUPercent: 10, Memory: 1000, Agents: 1, SimFPS: 50}})
+	mb.add(metricSample{MetricRegion, region, mgm.MetricPoint{CPUPercent: 30, Memory: 3000, Agents: 3, SimFPS: 40}})
+	mb.add(metricSample{MetricHost, "1", mgm.MetricPoint{CPUPercent: 80, Memory: 40}})
+	//hosts and regions are bucketed apart, even sharing an id
+	mb.add(metricSample{MetricRegion, "1", mgm.MetricPoint{CPUPercent: 5}})
+
+	start := time.Date(2016, 3, 1, 12, 30, 0, 0, time.UTC)
+	got := make(map[string]metricSample)
+	for _, s := range mb.average(start) {
+		got[s.subject+"/"+s.id] = s
+	}
+	want := map[string]mgm.MetricPoint{
+		MetricRegion + "/" + region: {Time: start, CPUPercent: 20, Memory: 2000, Agents: 2, SimFPS: 45, Samples: 2},
+		MetricHost + "/1":           {Time: start, CPUPercent: 80, Memory: 40, Samples: 1},
+		MetricRegion + "/1":         {Time: start, CPUPercent: 5, Samples: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v buckets, want %v", len(got), len(want))
+	}
+	for key, p := range want {
+		if got[key].point != p {
+			t.Errorf("%v: got %+v, want %+v", key, got[key].point, p)
+		}
+	}
+}
+
+func TestRollupStart(t *testing.T) {
+	at := time.Date(2016, 3, 1, 12, 34, 56, 0, time.UTC)
+	tests := []struct {
+		resolution time.Duration
+		want       time.Time
+	}{
+		{time.Minute, time.Date(2016, 3, 1, 12, 34, 0, 0, time.UTC)},
+		{time.Hour, time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{24 * time.Hour, time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if _, ok := metricRetention[tt.resolution]; !ok {
+			t.Errorf("%v is not a retained resolution", tt.resolution)
+		}
+		if got := rollupStart(at, tt.resolution); got != tt.want.Unix() {
+			t.Errorf("rollupStart at %v = %v, want %v", tt.resolution, time.Unix(got, 0).UTC(), tt.want)
+		}
+		//every minute of a rollup lands in the same row
+		if got := rollupStart(tt.want.Add(tt.resolution-time.Minute), tt.resolution); got != tt.want.Unix() {
+			t.Errorf("last minute of a %v rollup starts at %v", tt.resolution, time.Unix(got, 0).UTC())
+		}
+	}
+	if len(metricRetention) != len(tests) {
+		t.Errorf("%v retained resolutions, %v tested", len(metricRetention), len(tests))
+	}
+}
+
+func TestRecordStats(t *testing.T) {
+	m := MGMDB{metrics: make(chan metricSample, 1)}
+
+	//stopped hosts and regions are not sampled
+	m.RecordHostStat(mgm.HostStat{ID: 1})
+	m.RecordRegionStat(mgm.RegionStat{UUID: uuid.NewV4()})
+	if len(m.metrics) != 0 {
+		t.Fatal("stopped subjects were sampled")
+	}
+
+	//host cpu is averaged across cores
+	m.RecordHostStat(mgm.HostStat{ID: 7, Running: true, CPUPercent: []float64{10, 30, 50, 70}, MEMPercent: 25})
+	s := <-m.metrics
+	if s.subject != MetricHost || s.id != "7" || s.point.CPUPercent != 40 || s.point.Memory != 25 {
+		t.Errorf("host sample: got %+v", s)
+	}
+
+	r := mgm.RegionStat{UUID: uuid.NewV4(), Running: true, CPUPercent: 12, MemKB: 2048}
+	r.Sim.RootAgents = 4
+	r.Sim.SimFPS = 55
+	m.RecordRegionStat(r)
+	s = <-m.metrics
+	want := mgm.MetricPoint{CPUPercent: 12, Memory: 2048, Agents: 4, SimFPS: 55}
+	if s.subject != MetricRegion || s.id != r.UUID.String() || s.point != want {
+		t.Errorf("region sample: got %+v", s)
+	}
+
+	//a full queue drops samples rather than blocking stats updates
+	m.RecordRegionStat(r)
+	m.RecordRegionStat(r)
+	if len(m.metrics) != 1 {
+		t.Errorf("queue holds %v samples", len(m.metrics))
+	}
+}
+
+func TestQueryMetricsResolution(t *testing.T) {
+	m := MGMDB{}
+	if _, err := m.QueryMetrics(MetricHost, "1", 5*time.Minute, time.Now()); err == nil {
+		t.Error("unsupported resolution was accepted")
+	}
+}
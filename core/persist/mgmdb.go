@@ -26,14 +26,16 @@ type Notifier interface {
 // NewMGMDB constructs an MGMDB instance for use
 func NewMGMDB(db Database, osdb Database, sim simian.Connector, log logger.Log) MGMDB {
 	mgm := MGMDB{
-		db:   db,
-		osdb: osdb,
-		sim:  sim,
-		log:  logger.Wrap("MGMDB", log),
-		reqs: make(chan mgmReq, 64),
+		db:      db,
+		osdb:    osdb,
+		sim:     sim,
+		log:     logger.Wrap("MGMDB", log),
+		reqs:    make(chan mgmReq, 64),
+		metrics: make(chan metricSample, 256),
 	}
 
 	go mgm.process()
+	go mgm.recordMetrics()
 
 	return mgm
 }
@@ -52,6 +54,8 @@ type MGMDB struct {
 	sim  simian.Connector
 	log  logger.Log
 	reqs chan mgmReq
+
+	metrics chan metricSample
 }
 
 func (m MGMDB) process() {
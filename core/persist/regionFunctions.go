@@ -20,7 +20,8 @@ func (m MGMDB) PersistRegion(region mgm.Region) {
 	errMsg := fmt.Sprintf("Persisting region %v", region.UUID)
 	m.log.Info(errMsg)
 
-	_, err = con.Exec("REPLACE INTO regions VALUES (?,?,?,?,?,?,?,?,?,?)",
+	_, err = con.Exec("REPLACE INTO regions (uuid, name, size, httpPort, consolePort, consoleUname, consolePass, locX, locY, host, opensimVersion) "+
+		"VALUES (?,?,?,?,?,?,?,?,?,?,?)",
 		region.UUID.String(),
 		region.Name,
 		region.Size,
@@ -30,7 +31,8 @@ func (m MGMDB) PersistRegion(region mgm.Region) {
 		region.ConsolePass.String(),
 		region.LocX,
 		region.LocY,
-		region.Host)
+		region.Host,
+		region.Version)
 	if err != nil {
 		errMsg := fmt.Sprintf("Error updating region: %v", err.Error())
 		m.log.Error(errMsg)
@@ -48,7 +50,7 @@ func (m MGMDB) QueryRegions() []mgm.Region {
 	}
 	defer con.Close()
 	rows, err := con.Query(
-		"Select uuid, name, size, httpPort, consolePort, consoleUname, consolePass, locX, locY, host from regions")
+		"Select uuid, name, size, httpPort, consolePort, consoleUname, consolePass, locX, locY, host, IFNULL(opensimVersion, '') from regions")
 	if err != nil {
 		errMsg := fmt.Sprintf("Error reading regions: %v", err.Error())
 		m.log.Error(errMsg)
@@ -68,6 +70,7 @@ func (m MGMDB) QueryRegions() []mgm.Region {
 			&r.LocX,
 			&r.LocY,
 			&r.Host,
+			&r.Version,
 		)
 		if err != nil {
 			errMsg := fmt.Sprintf("Error scanning regions: %v", err.Error())
@@ -145,3 +148,19 @@ func (m MGMDB) QueryConfigs(r uuid.UUID) []mgm.ConfigOption {
 	}
 	return cfgs
 }
+
+// PurgeRegion removes a region record and its region-specific configuration from the database
+func (m MGMDB) PurgeRegion(id uuid.UUID) error {
+	con, err := m.db.getConnection()
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	_, err = con.Exec("DELETE FROM iniConfig WHERE region=?", id.String())
+	if err != nil {
+		return err
+	}
+	_, err = con.Exec("DELETE FROM regions WHERE uuid=?", id.String())
+	return err
+}
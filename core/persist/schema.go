@@ -0,0 +1,64 @@
+package persist
+
+import "fmt"
+
+// schemaColumns are columns added to existing MGM tables, with the ALTER TABLE definition for each
+var schemaColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"hosts", "secret", "VARCHAR(128) NULL"},
+	{"hosts", "minRegionPort", "INT NULL"},
+	{"hosts", "maxRegionPort", "INT NULL"},
+	{"hosts", "minConsolePort", "INT NULL"},
+	{"hosts", "maxConsolePort", "INT NULL"},
+	{"regions", "opensimVersion", "VARCHAR(64) NULL"},
+}
+
+// schemaTables are tables introduced after the original MGM schema
+var schemaTables = []string{
+	"CREATE TABLE IF NOT EXISTS metrics (subject VARCHAR(16), id VARCHAR(36), resolution INT, ts BIGINT, " +
+		"cpu DOUBLE, memory DOUBLE, agents DOUBLE, simFPS DOUBLE, samples INT, " +
+		"PRIMARY KEY (subject, id, resolution, ts))",
+	"CREATE TABLE IF NOT EXISTS consoleAudit (id BIGINT AUTO_INCREMENT PRIMARY KEY, region VARCHAR(36), user VARCHAR(36), " +
+		"name VARCHAR(64), command TEXT, allowed BOOL, reason TEXT, ts BIGINT)",
+	"CREATE TABLE IF NOT EXISTS regionEvents (id BIGINT AUTO_INCREMENT PRIMARY KEY, region VARCHAR(36), host BIGINT, " +
+		"fromState VARCHAR(16), toState VARCHAR(16), actor VARCHAR(64), reason TEXT, ts BIGINT, " +
+		"INDEX (region, ts), INDEX (host, ts))",
+}
+
+// UpgradeSchema brings an existing MGM database up to date, adding any missing columns and tables.
+// Columns are only added when absent, so it is safe to run on every startup.
+func (m MGMDB) UpgradeSchema() error {
+	con, err := m.db.getConnection()
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	for _, c := range schemaColumns {
+		var count int
+		err = con.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=? AND COLUMN_NAME=?",
+			c.table, c.column).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		m.log.Info("Adding column %v.%v", c.table, c.column)
+		_, err = con.Exec(fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v", c.table, c.column, c.definition))
+		if err != nil {
+			return fmt.Errorf("Error adding column %v.%v: %v", c.table, c.column, err.Error())
+		}
+	}
+
+	for _, t := range schemaTables {
+		_, err = con.Exec(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
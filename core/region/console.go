@@ -1,28 +1,33 @@
 package region
 
 import (
-	"encoding/xml"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
+	"sync"
 	"time"
 
 	"github.com/m-o-s-e-s/mgm/mgm"
-	"github.com/satori/go.uuid"
 )
 
+// ConsoleRelay carries rest console calls to a region over the connection to the node hosting it,
+// so region console ports never need to be reachable from MGM
+type ConsoleRelay interface {
+	RelayConsole(mgm.Region, mgm.Host, mgm.ConsoleCall) (mgm.ConsoleCall, error)
+}
+
 // NewRestConsole constructs and connects a rest console
-func NewRestConsole(r mgm.Region, h mgm.Host) (RestConsole, error) {
+func NewRestConsole(r mgm.Region, h mgm.Host, relay ConsoleRelay) (RestConsole, error) {
 	c := RestConsole{
+		region:  r,
+		host:    h,
+		relay:   relay,
+		session: &consoleSession{mutex: &sync.Mutex{}},
 		read:    make(chan []string, 36),
 		write:   make(chan string, 8),
 		closing: make(chan bool),
+		ended:   make(chan bool),
 	}
 
-	c.url = fmt.Sprintf("http://%v:%v/", h.Address, r.ConsolePort)
-
-	err := c.connect(r.ConsoleUname.String(), r.ConsolePass.String())
+	err := c.connect()
 	if err != nil {
 		return c, err
 	}
@@ -37,42 +42,58 @@ func NewRestConsole(r mgm.Region, h mgm.Host) (RestConsole, error) {
 
 //RestConsole is an object representing a rest console connection with a remote process
 type RestConsole struct {
-	url         string
-	sessionID   uuid.UUID
+	region      mgm.Region
+	host        mgm.Host
+	relay       ConsoleRelay
+	session     *consoleSession
 	read        chan []string
 	write       chan string
 	closing     chan bool
+	ended       chan bool
 	initialized bool
 }
 
+// consoleSession holds the upstream session id, shared by every copy of a console as it is renewed
+type consoleSession struct {
+	mutex *sync.Mutex
+	id    string
+}
+
+func (s *consoleSession) get() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.id
+}
+
+func (s *consoleSession) set(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.id = id
+}
+
+// consoleRetryDelay is how long a failed console read waits before reconnecting, so a node that cannot
+// reach its region is not hammered
+var consoleRetryDelay = 5 * time.Second
+
 //IsConnected is a simple test if the console is active or not
 func (c RestConsole) IsConnected() bool {
 	return c.initialized
 }
 
-func (c *RestConsole) connect(uname string, pass string) error {
-	resp, err := http.PostForm(c.url+"StartSession/", url.Values{"USER": {uname}, "PASS": {pass}})
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	type consoleConnectXML struct {
-		XMLName   xml.Name  `xml:"ConsoleSession"`
-		SessionID uuid.UUID `xml:"SessionID"`
-		Prompt    string
-	}
+func (c RestConsole) call(call string, cmd string) (mgm.ConsoleCall, error) {
+	return c.relay.RelayConsole(c.region, c.host, mgm.ConsoleCall{
+		Call:    call,
+		Session: c.session.get(),
+		Command: cmd,
+	})
+}
 
-	ss := consoleConnectXML{}
-	err = xml.Unmarshal(body, &ss)
+func (c RestConsole) connect() error {
+	result, err := c.call(mgm.ConsoleStart, "")
 	if err != nil {
 		return err
 	}
-	c.sessionID = ss.SessionID
+	c.session.set(result.Session)
 	return nil
 }
 
@@ -83,35 +104,25 @@ func (c RestConsole) readProcess() {
 			return
 		default:
 			//not closing, lets read
-			resp, err := http.PostForm(c.url+"ReadResponses/"+c.sessionID.String()+"/", url.Values{"ID": {c.sessionID.String()}})
+			result, err := c.call(mgm.ConsoleRead, "")
 			if err != nil {
-				c.read <- []string{"Error opening console"}
+				select {
+				case <-c.closing:
+					return
+				case <-time.After(consoleRetryDelay):
+				}
+				//a region that restarted has forgotten our session, one that stopped refuses a new one
+				if cerr := c.connect(); cerr != nil {
+					c.emit([]string{fmt.Sprintf("Console disconnected: %v", err.Error())})
+					close(c.ended)
+					return
+				}
+				c.emit([]string{"Console reconnected"})
 				continue
 			}
-			defer resp.Body.Close()
-
-			if resp.ContentLength == 0 {
-				continue
-			}
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				c.read <- []string{"Error reading from console"}
-				return
-			}
-
-			type consoleConnectXML struct {
-				XMLName xml.Name `xml:"ConsoleSession"`
-				Lines   []string `xml:"Line"`
-			}
-
-			ss := consoleConnectXML{}
-
-			err = xml.Unmarshal(body, &ss)
-			if err != nil {
-				c.read <- []string{err.Error()}
+			if len(result.Lines) > 0 {
+				c.emit(result.Lines)
 			}
-
-			c.read <- ss.Lines
 		}
 	}
 }
@@ -122,28 +133,30 @@ func (c RestConsole) writeProcess() {
 		case <-c.closing:
 			return
 		case cmd := <-c.write:
-			_, err := http.PostForm(
-				c.url+"SessionCommand/",
-				url.Values{
-					"ID":      {c.sessionID.String()},
-					"COMMAND": {cmd},
-				},
-			)
+			_, err := c.call(mgm.ConsoleCommand, cmd)
 			timestamp := time.Now()
 			h, m, s := timestamp.Clock()
-			c.read <- []string{fmt.Sprintf("0:normal:%v:%v:%v - %v", h, m, s, cmd)}
+			c.emit([]string{fmt.Sprintf("0:normal:%v:%v:%v - %v", h, m, s, cmd)})
 			if err != nil {
-				c.read <- []string{"Error writing to console"}
+				c.emit([]string{"Error writing to console"})
 			}
 		}
 	}
 }
 
+// emit passes output to the reader, unless the console is closed and nobody is reading
+func (c RestConsole) emit(lines []string) {
+	select {
+	case <-c.closing:
+	case c.read <- lines:
+	}
+}
+
 //Close closes a rest console session with a remote instance
 func (c *RestConsole) Close() {
 	if c.initialized {
-		http.PostForm(c.url+"CloseSession/", url.Values{"ID": {c.sessionID.String()}})
 		close(c.closing)
+		c.call(mgm.ConsoleClose, "")
 		c.initialized = false
 	}
 }
@@ -152,8 +165,15 @@ func (c RestConsole) Read() <-chan []string {
 	return c.read
 }
 
+// Ended is closed once the console has lost its region for good, after its last output is read
+func (c RestConsole) Ended() <-chan bool {
+	return c.ended
+}
+
 func (c RestConsole) Write(cmd string) {
-	if c.initialized {
-		c.write <- cmd
+	//a console closed from under us will never take the command
+	select {
+	case <-c.closing:
+	case c.write <- cmd:
 	}
 }
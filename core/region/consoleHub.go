@@ -0,0 +1,267 @@
+package region
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/core/logger"
+	"github.com/m-o-s-e-s/mgm/core/persist"
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+// consoleScrollback is how many lines of output are kept for subscribers joining a running console
+const consoleScrollback = 500
+
+// NewConsoleHub constructs a ConsoleHub relaying region consoles through relay, with commands checked
+// against policy and recorded in the audit trail
+func NewConsoleHub(relay ConsoleRelay, policy ConsolePolicy, pers persist.MGMDB, log logger.Log) ConsoleHub {
+	return ConsoleHub{
+		relay:    relay,
+		policy:   policy,
+		mgm:      pers,
+		log:      logger.Wrap("CONSOLE", log),
+		consoles: make(map[uuid.UUID]*hubConsole),
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// ConsoleHub shares a single upstream console session per region between any number of subscribers
+type ConsoleHub struct {
+	relay    ConsoleRelay
+	policy   ConsolePolicy
+	mgm      persist.MGMDB
+	log      logger.Log
+	consoles map[uuid.UUID]*hubConsole
+	mutex    *sync.Mutex
+}
+
+type hubConsole struct {
+	console     RestConsole
+	subscribers map[string]*consoleSubscriber
+	scrollback  []string
+	done        chan bool
+}
+
+// subscriberQueue is how many batches of output may wait on a slow subscriber before it misses output
+const subscriberQueue = 256
+
+// consoleSubscriber receives output in order through its own queue, so a slow subscriber holds up
+// neither the hub nor the other subscribers
+type consoleSubscriber struct {
+	user    ConsoleUser
+	deliver func(mgm.RegionConsole)
+	queue   chan mgm.RegionConsole
+}
+
+func newConsoleSubscriber(u ConsoleUser, deliver func(mgm.RegionConsole)) *consoleSubscriber {
+	sub := &consoleSubscriber{u, deliver, make(chan mgm.RegionConsole, subscriberQueue)}
+	go func() {
+		for rc := range sub.queue {
+			sub.deliver(rc)
+		}
+	}()
+	return sub
+}
+
+// send queues output for the subscriber, the hub mutex must be held
+func (s *consoleSubscriber) send(rc mgm.RegionConsole) bool {
+	select {
+	case s.queue <- rc:
+		return true
+	default:
+		return false
+	}
+}
+
+// Subscribe attaches a subscriber to the console of a region, connecting upstream if no one else is.
+// Output is passed to deliver in order as it arrives, starting with the scrollback.
+func (h ConsoleHub) Subscribe(r mgm.Region, host mgm.Host, id string, u ConsoleUser, deliver func(mgm.RegionConsole)) error {
+	if !h.policy.CanOpen(u) {
+		return errors.New("Permission Denied")
+	}
+
+	for {
+		h.mutex.Lock()
+		if hc, ok := h.consoles[r.UUID]; ok {
+			h.attach(r.UUID, hc, id, u, deliver)
+			h.mutex.Unlock()
+			return nil
+		}
+		h.mutex.Unlock()
+
+		//connecting waits on the node, do not hold the hub for it
+		h.log.Info("Opening console for region %v", r.UUID)
+		c, err := NewRestConsole(r, host, h.relay)
+		if err != nil {
+			return err
+		}
+
+		h.mutex.Lock()
+		if _, ok := h.consoles[r.UUID]; ok {
+			//someone else connected first, theirs is used
+			h.mutex.Unlock()
+			go c.Close()
+			continue
+		}
+		hc := &hubConsole{
+			console:     c,
+			subscribers: make(map[string]*consoleSubscriber),
+			done:        make(chan bool),
+		}
+		h.consoles[r.UUID] = hc
+		go h.fanOut(r.UUID, hc, c.Read(), c.Ended())
+		h.attach(r.UUID, hc, id, u, deliver)
+		h.mutex.Unlock()
+		return nil
+	}
+}
+
+// attach adds a subscriber to an open console and queues the scrollback for it, the hub mutex must be held
+func (h ConsoleHub) attach(region uuid.UUID, hc *hubConsole, id string, u ConsoleUser, deliver func(mgm.RegionConsole)) {
+	if old, ok := hc.subscribers[id]; ok {
+		close(old.queue)
+	}
+	sub := newConsoleSubscriber(u, deliver)
+	hc.subscribers[id] = sub
+	if len(hc.scrollback) > 0 {
+		lines := make([]string, len(hc.scrollback))
+		copy(lines, hc.scrollback)
+		sub.send(mgm.RegionConsole{UUID: region, Lines: lines})
+	}
+}
+
+// Unsubscribe detaches a subscriber from a region console, closing the upstream session once nobody is left
+func (h ConsoleHub) Unsubscribe(region uuid.UUID, id string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.unsubscribe(region, id)
+}
+
+// UnsubscribeAll detaches a subscriber from every console, such as when a client disconnects
+func (h ConsoleHub) UnsubscribeAll(id string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for region := range h.consoles {
+		h.unsubscribe(region, id)
+	}
+}
+
+func (h ConsoleHub) unsubscribe(region uuid.UUID, id string) {
+	hc, ok := h.consoles[region]
+	if !ok {
+		return
+	}
+	if sub, ok := hc.subscribers[id]; ok {
+		close(sub.queue)
+		delete(hc.subscribers, id)
+	}
+	if len(hc.subscribers) > 0 {
+		return
+	}
+	h.log.Info("Closing console for region %v, no subscribers remain", region)
+	close(hc.done)
+	delete(h.consoles, region)
+	//closing waits on the node, do not hold the hub for it.  A copy is closed, as Write may still be reading hc.console
+	c := hc.console
+	go c.Close()
+}
+
+// Write sends a command to a region console on behalf of one of its subscribers, if policy permits it.
+// Every command is recorded in the audit trail, rejected commands are also echoed to their sender.
+func (h ConsoleHub) Write(region uuid.UUID, id string, cmd string) error {
+	h.mutex.Lock()
+	hc, ok := h.consoles[region]
+	if !ok {
+		h.mutex.Unlock()
+		return errors.New("Console is not open")
+	}
+	sub, ok := hc.subscribers[id]
+	h.mutex.Unlock()
+	if !ok {
+		return errors.New("Console is not open")
+	}
+
+	err := h.policy.Check(sub.user, cmd)
+	audit := mgm.ConsoleAudit{
+		Region:    region,
+		User:      sub.user.ID,
+		Name:      sub.user.Name,
+		Command:   cmd,
+		Allowed:   err == nil,
+		Timestamp: time.Now(),
+	}
+	if err != nil {
+		audit.Reason = err.Error()
+	}
+	//recorded before the command goes anywhere, so the trail cannot fall behind the console
+	if aerr := h.mgm.RecordConsoleCommand(audit); aerr != nil {
+		h.log.Error("Error recording console command %q from %v on region %v: %v", cmd, sub.user.Name, region, aerr.Error())
+	}
+
+	if err != nil {
+		h.log.Info("Rejected console command %q from %v on region %v: %v", cmd, sub.user.Name, region, err.Error())
+		h.mutex.Lock()
+		if s, ok := hc.subscribers[id]; ok {
+			s.send(mgm.RegionConsole{UUID: region, Lines: []string{"Rejected: " + err.Error()}})
+		}
+		h.mutex.Unlock()
+		return err
+	}
+	h.log.Info("Console command %q from %v on region %v", cmd, sub.user.Name, region)
+	hc.console.Write(cmd)
+	return nil
+}
+
+func (h ConsoleHub) fanOut(region uuid.UUID, hc *hubConsole, read <-chan []string, ended <-chan bool) {
+	for {
+		select {
+		case <-hc.done:
+			return
+		case lines := <-read:
+			h.publish(region, hc, lines)
+		case <-ended:
+			//pass on the last output, which says why the console ended
+			for len(read) > 0 {
+				h.publish(region, hc, <-read)
+			}
+			h.drop(region, hc)
+			return
+		}
+	}
+}
+
+// publish records output in the scrollback and queues it for every subscriber
+func (h ConsoleHub) publish(region uuid.UUID, hc *hubConsole, lines []string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	hc.scrollback = append(hc.scrollback, lines...)
+	if len(hc.scrollback) > consoleScrollback {
+		hc.scrollback = hc.scrollback[len(hc.scrollback)-consoleScrollback:]
+	}
+	//queueing never blocks, delivery happens on each subscriber's own goroutine
+	for id, sub := range hc.subscribers {
+		if !sub.send(mgm.RegionConsole{UUID: region, Lines: lines}) {
+			h.log.Info("Console subscriber %v on region %v is not keeping up, output dropped", id, region)
+		}
+	}
+}
+
+// drop discards a console that lost its region, so the next subscriber connects afresh
+func (h ConsoleHub) drop(region uuid.UUID, hc *hubConsole) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.consoles[region] != hc {
+		return
+	}
+	h.log.Info("Console for region %v ended, it will be reopened by the next subscriber", region)
+	for id, sub := range hc.subscribers {
+		close(sub.queue)
+		delete(hc.subscribers, id)
+	}
+	close(hc.done)
+	delete(h.consoles, region)
+	c := hc.console
+	go c.Close()
+}
@@ -0,0 +1,323 @@
+package region
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/core/persist"
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+type testLog struct{}
+
+func (testLog) Trace(format string, v ...interface{}) {}
+func (testLog) Debug(format string, v ...interface{}) {}
+func (testLog) Info(format string, v ...interface{})  {}
+func (testLog) Warn(format string, v ...interface{})  {}
+func (testLog) Error(format string, v ...interface{}) {}
+func (testLog) Fatal(format string, v ...interface{}) {}
+
+// testRelay stands in for a node, serving console output from a channel.  Each region start is a
+// generation, sessions opened with an earlier generation are no longer recognised.
+type testRelay struct {
+	outputs map[uuid.UUID]chan []string
+	mutex   *sync.Mutex
+	calls   map[string]int
+	state   *relayState
+}
+
+type relayState struct {
+	generation int
+	down       bool
+}
+
+func newTestRelay() testRelay {
+	return testRelay{make(map[uuid.UUID]chan []string), &sync.Mutex{}, make(map[string]int), &relayState{generation: 1}}
+}
+
+// output is where console output for a region is served from, each region has its own
+func (r testRelay) output(region uuid.UUID) chan []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.outputs[region]; !ok {
+		r.outputs[region] = make(chan []string, 8)
+	}
+	return r.outputs[region]
+}
+
+// restart forgets every open session, and leaves the region down if asked
+func (r testRelay) restart(down bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.state.generation++
+	r.state.down = down
+}
+
+func (r testRelay) RelayConsole(reg mgm.Region, h mgm.Host, call mgm.ConsoleCall) (mgm.ConsoleCall, error) {
+	r.mutex.Lock()
+	r.calls[call.Call]++
+	session := fmt.Sprint("session", r.state.generation)
+	down := r.state.down
+	r.mutex.Unlock()
+	switch call.Call {
+	case mgm.ConsoleStart:
+		if down {
+			return mgm.ConsoleCall{}, errors.New("Region is not running")
+		}
+		return mgm.ConsoleCall{Session: session}, nil
+	case mgm.ConsoleRead:
+		if call.Session != session {
+			return mgm.ConsoleCall{}, errors.New("Console session not found")
+		}
+		select {
+		case lines := <-r.output(reg.UUID):
+			return mgm.ConsoleCall{Lines: lines}, nil
+		case <-time.After(10 * time.Millisecond):
+			return mgm.ConsoleCall{}, nil
+		}
+	}
+	return mgm.ConsoleCall{}, nil
+}
+
+func (r testRelay) count(call string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.calls[call]
+}
+
+// collector gathers delivered console output
+type collector struct {
+	mutex *sync.Mutex
+	lines []string
+}
+
+func newCollector() *collector {
+	return &collector{mutex: &sync.Mutex{}}
+}
+
+func (c *collector) deliver(rc mgm.RegionConsole) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lines = append(c.lines, rc.Lines...)
+}
+
+// wait polls until n lines have been delivered, returning them
+func (c *collector) wait(t *testing.T, n int) []string {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c.mutex.Lock()
+		lines := append([]string{}, c.lines...)
+		c.mutex.Unlock()
+		if len(lines) >= n || time.Now().After(deadline) {
+			return lines
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func testHub(t *testing.T) (ConsoleHub, testRelay) {
+	policy, err := NewConsolePolicy(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := newTestRelay()
+	return NewConsoleHub(relay, policy, persist.MGMDB{}, testLog{}), relay
+}
+
+func TestConsoleHubFanOut(t *testing.T) {
+	hub, relay := testHub(t)
+	r := mgm.Region{UUID: uuid.NewV4()}
+	admin := ConsoleUser{ID: uuid.NewV4(), Roles: []string{RoleAdmin}}
+
+	if err := hub.Subscribe(r, mgm.Host{}, "other", ConsoleUser{ID: uuid.NewV4()}, newCollector().deliver); err == nil {
+		t.Error("subscriber without console rights was accepted")
+	}
+
+	first := newCollector()
+	second := newCollector()
+	if err := hub.Subscribe(r, mgm.Host{}, "first", admin, first.deliver); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.Subscribe(r, mgm.Host{}, "second", admin, second.deliver); err != nil {
+		t.Fatal(err)
+	}
+	if n := relay.count(mgm.ConsoleStart); n != 1 {
+		t.Fatalf("%v upstream sessions opened, want 1", n)
+	}
+
+	relay.output(r.UUID) <- []string{"one", "two"}
+	relay.output(r.UUID) <- []string{"three"}
+	want := []string{"one", "two", "three"}
+	for name, c := range map[string]*collector{"first": first, "second": second} {
+		if got := c.wait(t, 3); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v subscriber got %v, want %v", name, got, want)
+		}
+	}
+
+	//a late subscriber starts with the scrollback
+	late := newCollector()
+	if err := hub.Subscribe(r, mgm.Host{}, "late", admin, late.deliver); err != nil {
+		t.Fatal(err)
+	}
+	if got := late.wait(t, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("late subscriber got %v, want %v", got, want)
+	}
+}
+
+func TestConsoleHubSlowSubscriber(t *testing.T) {
+	hub, relay := testHub(t)
+	r := mgm.Region{UUID: uuid.NewV4()}
+	admin := ConsoleUser{ID: uuid.NewV4(), Roles: []string{RoleAdmin}}
+
+	stuck := make(chan bool)
+	defer close(stuck)
+	hub.Subscribe(r, mgm.Host{}, "slow", admin, func(mgm.RegionConsole) { <-stuck })
+	fast := newCollector()
+	hub.Subscribe(r, mgm.Host{}, "fast", admin, fast.deliver)
+
+	//far more output than a subscriber queue holds, the slow subscriber drops it rather than blocking
+	go func() {
+		for i := 0; i < subscriberQueue*2; i++ {
+			relay.output(r.UUID) <- []string{"line"}
+		}
+	}()
+	if got := fast.wait(t, subscriberQueue*2); len(got) != subscriberQueue*2 {
+		t.Errorf("fast subscriber got %v lines, want %v", len(got), subscriberQueue*2)
+	}
+}
+
+func TestConsoleHubUnsubscribe(t *testing.T) {
+	hub, relay := testHub(t)
+	r := mgm.Region{UUID: uuid.NewV4()}
+	other := mgm.Region{UUID: uuid.NewV4()}
+	admin := ConsoleUser{ID: uuid.NewV4(), Roles: []string{RoleAdmin}}
+
+	leaving := newCollector()
+	staying := newCollector()
+	hub.Subscribe(r, mgm.Host{}, "leaving", admin, leaving.deliver)
+	hub.Subscribe(r, mgm.Host{}, "staying", admin, staying.deliver)
+	hub.Subscribe(other, mgm.Host{}, "leaving", admin, newCollector().deliver)
+
+	relay.output(r.UUID) <- []string{"before"}
+	leaving.wait(t, 1)
+	hub.Unsubscribe(r.UUID, "leaving")
+	if err := hub.Write(r.UUID, "leaving", "show stats"); err == nil {
+		t.Error("unsubscribed client could still write")
+	}
+
+	relay.output(r.UUID) <- []string{"after"}
+	if got := staying.wait(t, 2); !reflect.DeepEqual(got, []string{"before", "after"}) {
+		t.Errorf("remaining subscriber got %v", got)
+	}
+	if got := leaving.wait(t, 1); !reflect.DeepEqual(got, []string{"before"}) {
+		t.Errorf("unsubscribed client got %v", got)
+	}
+	if n := relay.count(mgm.ConsoleClose); n != 0 {
+		t.Errorf("upstream closed with subscribers remaining")
+	}
+
+	//the last subscriber leaving closes the upstream sessions
+	hub.Unsubscribe(r.UUID, "staying")
+	hub.UnsubscribeAll("leaving")
+	deadline := time.Now().Add(2 * time.Second)
+	for relay.count(mgm.ConsoleClose) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := relay.count(mgm.ConsoleClose); n != 2 {
+		t.Errorf("%v upstream sessions closed, want 2", n)
+	}
+	hub.mutex.Lock()
+	open := len(hub.consoles)
+	hub.mutex.Unlock()
+	if open != 0 {
+		t.Errorf("%v consoles still open", open)
+	}
+
+	//unsubscribing twice, or from a closed console, is harmless
+	hub.Unsubscribe(r.UUID, "staying")
+	hub.UnsubscribeAll("staying")
+}
+
+func TestConsoleHubRejectedWrite(t *testing.T) {
+	hub, _ := testHub(t)
+	r := mgm.Region{UUID: uuid.NewV4()}
+	admin := ConsoleUser{ID: uuid.NewV4(), Roles: []string{RoleAdmin}}
+	c := newCollector()
+	hub.Subscribe(r, mgm.Host{}, "admin", admin, c.deliver)
+
+	//rejected commands are echoed to their sender, even when the audit trail cannot be written
+	if err := hub.Write(r.UUID, "admin", "alert hi\nshutdown"); err == nil {
+		t.Fatal("command with a newline was accepted")
+	}
+	if got := c.wait(t, 1); len(got) != 1 || got[0] != "Rejected: Command contains control characters" {
+		t.Errorf("sender got %v", got)
+	}
+}
+
+func TestConsoleHubRegionRestart(t *testing.T) {
+	defer func(d time.Duration) { consoleRetryDelay = d }(consoleRetryDelay)
+	consoleRetryDelay = time.Millisecond
+	hub, relay := testHub(t)
+	r := mgm.Region{UUID: uuid.NewV4()}
+	admin := ConsoleUser{ID: uuid.NewV4(), Roles: []string{RoleAdmin}}
+
+	c := newCollector()
+	hub.Subscribe(r, mgm.Host{}, "admin", admin, c.deliver)
+
+	//a restarted region forgets the session, which is renewed without the subscriber noticing
+	relay.restart(false)
+	if got := c.wait(t, 1); !reflect.DeepEqual(got, []string{"Console reconnected"}) {
+		t.Fatalf("subscriber got %v, want the reconnect notice", got)
+	}
+	relay.output(r.UUID) <- []string{"after restart"}
+	if got := c.wait(t, 2); len(got) != 2 || got[1] != "after restart" {
+		t.Errorf("subscriber got %v after the session was renewed", got)
+	}
+
+	//a stopped region ends the console, its subscribers are told and it is rebuilt on the next subscribe
+	relay.restart(true)
+	got := c.wait(t, 3)
+	if len(got) != 3 || got[2] != "Console disconnected: Console session not found" {
+		t.Fatalf("subscriber got %v, want the disconnect notice", got)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		hub.mutex.Lock()
+		open := len(hub.consoles)
+		hub.mutex.Unlock()
+		if open == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("ended console was kept")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := hub.Write(r.UUID, "admin", "show stats"); err == nil {
+		t.Error("wrote to an ended console")
+	}
+	//nothing more arrives from the ended console
+	time.Sleep(20 * time.Millisecond)
+	if got := c.wait(t, 3); len(got) != 3 {
+		t.Errorf("ended console kept delivering: %v", got)
+	}
+
+	relay.restart(false)
+	starts := relay.count(mgm.ConsoleStart)
+	fresh := newCollector()
+	if err := hub.Subscribe(r, mgm.Host{}, "admin", admin, fresh.deliver); err != nil {
+		t.Fatal(err)
+	}
+	if n := relay.count(mgm.ConsoleStart); n != starts+1 {
+		t.Errorf("resubscribing opened %v sessions, want 1", n-starts)
+	}
+	relay.output(r.UUID) <- []string{"back"}
+	if got := fresh.wait(t, 1); len(got) != 1 || got[0] != "back" {
+		t.Errorf("resubscribed client got %v", got)
+	}
+}
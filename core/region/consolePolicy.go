@@ -0,0 +1,171 @@
+package region
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/satori/go.uuid"
+)
+
+// Roles a user may hold over a region, granted console rules by name
+const (
+	RoleAdmin         = "admin"
+	RoleEstateOwner   = "estate-owner"
+	RoleEstateManager = "estate-manager"
+)
+
+// ConsoleUser identifies who is operating a console, and what they are to the region
+type ConsoleUser struct {
+	ID          uuid.UUID
+	Name        string
+	AccessLevel uint8
+	Roles       []string
+}
+
+// ConsoleRule grants console commands to users holding one of Roles, or with at least AccessLevel.
+// Allow and Deny are patterns where * matches anything, such as "alert *" or "load oar*".
+type ConsoleRule struct {
+	Roles       []string
+	AccessLevel uint8
+	Allow       []string
+	Deny        []string
+}
+
+// defaultConsoleRules apply when no policy is configured, keeping consoles to administrators
+var defaultConsoleRules = []ConsoleRule{
+	{Roles: []string{RoleAdmin}, Allow: []string{"*"}},
+}
+
+type consoleRule struct {
+	roles       []string
+	accessLevel uint8
+	allow       []*regexp.Regexp
+	deny        []*regexp.Regexp
+}
+
+// ConsolePolicy decides which console commands a user may run.  A command must be allowed by a
+// rule applying to the user, and not denied by that same rule.  A rule's denials only narrow what
+// it grants, so a user holding several roles keeps everything any one of their rules allows.
+type ConsolePolicy struct {
+	rules []consoleRule
+}
+
+// NewConsolePolicy compiles console rules into a policy, falling back to administrators only without any
+func NewConsolePolicy(rules []ConsoleRule) (ConsolePolicy, error) {
+	if len(rules) == 0 {
+		rules = defaultConsoleRules
+	}
+	p := ConsolePolicy{}
+	for _, r := range rules {
+		cr := consoleRule{roles: r.Roles, accessLevel: r.AccessLevel}
+		for _, pattern := range r.Allow {
+			re, err := compileCommandPattern(pattern)
+			if err != nil {
+				return p, err
+			}
+			cr.allow = append(cr.allow, re)
+		}
+		for _, pattern := range r.Deny {
+			re, err := compileCommandPattern(pattern)
+			if err != nil {
+				return p, err
+			}
+			cr.deny = append(cr.deny, re)
+		}
+		p.rules = append(p.rules, cr)
+	}
+	return p, nil
+}
+
+func compileCommandPattern(pattern string) (*regexp.Regexp, error) {
+	parts := strings.Split(normalizeCommand(pattern), "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	re, err := regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid console pattern %q: %v", pattern, err.Error())
+	}
+	return re, nil
+}
+
+// normalizeCommand folds case and whitespace, so spacing cannot be used to slip past a pattern
+func normalizeCommand(cmd string) string {
+	return strings.Join(strings.Fields(strings.ToLower(cmd)), " ")
+}
+
+func (r consoleRule) appliesTo(u ConsoleUser) bool {
+	if r.accessLevel > 0 && u.AccessLevel >= r.accessLevel {
+		return true
+	}
+	for _, role := range r.roles {
+		for _, held := range u.Roles {
+			if role == held {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CanOpen tests if any rule grants the user commands, which is required to open a console at all
+func (p ConsolePolicy) CanOpen(u ConsoleUser) bool {
+	for _, r := range p.rules {
+		if r.appliesTo(u) && len(r.allow) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Check tests if the user may run a command, returning the reason if they may not.  Commands carrying
+// newlines or other control characters are refused outright, as the console would run them as several.
+func (p ConsolePolicy) Check(u ConsoleUser, cmd string) error {
+	if strings.IndexFunc(cmd, unicode.IsControl) >= 0 {
+		return errors.New("Command contains control characters")
+	}
+	cmd = normalizeCommand(cmd)
+	if cmd == "" {
+		return errors.New("Empty command")
+	}
+	denied := false
+	for _, r := range p.rules {
+		if !r.appliesTo(u) {
+			continue
+		}
+		switch r.grant(cmd) {
+		case ruleAllows:
+			return nil
+		case ruleDenies:
+			denied = true
+		}
+	}
+	if denied {
+		return fmt.Errorf("Command %q is denied", cmd)
+	}
+	return fmt.Errorf("Command %q is not permitted", cmd)
+}
+
+const (
+	ruleIgnores = iota
+	ruleAllows
+	ruleDenies
+)
+
+// grant tests a normalized command against a single rule, where the rule's denials override its own allows
+func (r consoleRule) grant(cmd string) int {
+	for _, re := range r.deny {
+		if re.MatchString(cmd) {
+			return ruleDenies
+		}
+	}
+	for _, re := range r.allow {
+		if re.MatchString(cmd) {
+			return ruleAllows
+		}
+	}
+	return ruleIgnores
+}
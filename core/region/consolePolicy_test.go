@@ -0,0 +1,115 @@
+package region
+
+import (
+	"testing"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestConsolePolicy(t *testing.T) {
+	p, err := NewConsolePolicy([]ConsoleRule{
+		{Roles: []string{RoleAdmin}, Allow: []string{"*"}},
+		{Roles: []string{RoleEstateOwner}, Allow: []string{"alert *", "show *", "load oar*"}, Deny: []string{"show users*"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := ConsoleUser{ID: uuid.NewV4(), Name: "Admin", Roles: []string{RoleAdmin}}
+	owner := ConsoleUser{ID: uuid.NewV4(), Name: "Owner", Roles: []string{RoleEstateOwner}}
+	other := ConsoleUser{ID: uuid.NewV4(), Name: "Other"}
+	adminOwner := ConsoleUser{ID: uuid.NewV4(), Name: "Admin Owner", Roles: []string{RoleAdmin, RoleEstateOwner}}
+
+	for _, tt := range []struct {
+		name string
+		u    ConsoleUser
+		want bool
+	}{
+		{"admin", admin, true},
+		{"estate owner", owner, true},
+		{"user without a role", other, false},
+	} {
+		if got := p.CanOpen(tt.u); got != tt.want {
+			t.Errorf("CanOpen %v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		u       ConsoleUser
+		cmd     string
+		allowed bool
+	}{
+		{"admin runs anything", admin, "shutdown", true},
+		{"owner runs an allowed command", owner, "alert restarting soon", true},
+		{"owner runs a command outside the rule", owner, "shutdown", false},
+		{"owner runs a denied command", owner, "show users full", false},
+		{"deny wins over allow", owner, "show users", false},
+		{"other users run nothing", other, "show stats", false},
+		{"admin owner runs a command denied to owners", adminOwner, "show users", true},
+		{"admin owner runs a command outside the owner rule", adminOwner, "shutdown", true},
+		{"admin owner runs an owner command", adminOwner, "alert hello", true},
+		{"case is folded", owner, "ALERT hello", true},
+		{"case cannot slip past a deny", owner, "Show Users", false},
+		{"spacing is folded", owner, "  alert    hello  ", true},
+		{"spacing cannot slip past a deny", owner, "show   users", false},
+		{"empty command", admin, "   ", false},
+		{"newline is refused", admin, "alert hi\nshutdown", false},
+		{"carriage return is refused", owner, "alert hi\rshutdown", false},
+		{"tab is refused", owner, "alert\thello", false},
+		{"nul is refused", admin, "alert hi\x00", false},
+		{"escape is refused", admin, "alert \x1b[2J", false},
+	}
+	for _, tt := range tests {
+		err := p.Check(tt.u, tt.cmd)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%v: got %v, want allowed %v", tt.name, err, tt.allowed)
+		}
+	}
+}
+
+func TestDefaultConsolePolicy(t *testing.T) {
+	p, err := NewConsolePolicy(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := ConsoleUser{Roles: []string{RoleAdmin}}
+	owner := ConsoleUser{Roles: []string{RoleEstateOwner}, AccessLevel: 200}
+	if !p.CanOpen(admin) || p.Check(admin, "show stats") != nil {
+		t.Error("default policy refuses administrators")
+	}
+	if p.CanOpen(owner) || p.Check(owner, "show stats") == nil {
+		t.Error("default policy admits non-administrators")
+	}
+}
+
+func TestConsolePolicyAccessLevel(t *testing.T) {
+	p, err := NewConsolePolicy([]ConsoleRule{{AccessLevel: 250, Allow: []string{"show *"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Check(ConsoleUser{AccessLevel: 250}, "show stats"); err != nil {
+		t.Errorf("access level at the threshold refused: %v", err)
+	}
+	if err := p.Check(ConsoleUser{AccessLevel: 249}, "show stats"); err == nil {
+		t.Error("access level below the threshold allowed")
+	}
+}
+
+func TestConsolePolicyAdminEstateOwner(t *testing.T) {
+	p, err := NewConsolePolicy([]ConsoleRule{
+		{Roles: []string{RoleAdmin}, Allow: []string{"*"}},
+		{Roles: []string{RoleEstateOwner, RoleEstateManager}, Allow: []string{"alert *", "show *"}, Deny: []string{"load oar*", "save oar*"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminOwner := ConsoleUser{Roles: []string{RoleAdmin, RoleEstateOwner}}
+	owner := ConsoleUser{Roles: []string{RoleEstateOwner}}
+	if err := p.Check(adminOwner, "load oar region.oar"); err != nil {
+		t.Errorf("admin owning the estate refused: %v", err)
+	}
+	if err := p.Check(owner, "load oar region.oar"); err == nil {
+		t.Error("estate owner allowed a denied command")
+	}
+}
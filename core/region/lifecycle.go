@@ -0,0 +1,275 @@
+package region
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+// lifecycle lists the states a region may move to from each state
+var lifecycle = map[string][]string{
+	mgm.RegionStopped:  {mgm.RegionStarting},
+	mgm.RegionStarting: {mgm.RegionReady, mgm.RegionStopping, mgm.RegionCrashed, mgm.RegionFailed, mgm.RegionStopped},
+	mgm.RegionReady:    {mgm.RegionStopping, mgm.RegionCrashed, mgm.RegionStopped},
+	mgm.RegionStopping: {mgm.RegionStopped, mgm.RegionCrashed, mgm.RegionFailed},
+	//stopping a crashed or failed region cancels any restart its host has scheduled
+	mgm.RegionCrashed: {mgm.RegionStarting, mgm.RegionStopping, mgm.RegionStopped},
+	mgm.RegionFailed:  {mgm.RegionStarting, mgm.RegionStopping, mgm.RegionStopped},
+}
+
+// requestGrace is how long a requested transition is given to show up in reports from the host,
+// during which reports of the state it left are stale
+const requestGrace = 15 * time.Second
+
+type lifecycleState struct {
+	state         string
+	requested     time.Time
+	requestedFrom string
+}
+
+func canTransition(from string, to string) bool {
+	for _, s := range lifecycle[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRegionState retrieves the lifecycle state of a region
+func (m Manager) GetRegionState(id uuid.UUID) string {
+	m.lsMutex.Lock()
+	defer m.lsMutex.Unlock()
+	return m.stateOf(id).state
+}
+
+// RequestTransition moves a region into a new state on behalf of actor, such as an operator starting it,
+// failing if the region cannot make that transition from its current state.  The returned function
+// undoes the transition, for when the host then refuses the request.
+func (m Manager) RequestTransition(id uuid.UUID, to string, actor string, reason string) (func(string), error) {
+	r, ok := m.GetRegion(id)
+	if !ok {
+		return nil, errors.New("Region does not exist")
+	}
+
+	m.lsMutex.Lock()
+	defer m.lsMutex.Unlock()
+	from := m.stateOf(id).state
+	//a stop may be asked for again, such as a kill during a graceful stop, but a second start would
+	//place and launch the region twice
+	if from == to && to == mgm.RegionStarting {
+		return nil, errors.New("Region is already starting")
+	}
+	if from == to {
+		return func(string) {}, nil
+	}
+	if !canTransition(from, to) {
+		return nil, fmt.Errorf("Region is %v, it cannot move to %v", from, to)
+	}
+	m.lifecycle[id] = lifecycleState{state: to, requested: time.Now(), requestedFrom: from}
+	m.recordTransition(r, from, to, actor, reason)
+
+	return func(reason string) {
+		m.lsMutex.Lock()
+		defer m.lsMutex.Unlock()
+		//the host may already have moved the region on
+		if m.stateOf(id).state == to {
+			m.lifecycle[id] = lifecycleState{state: from}
+			m.recordTransition(r, to, from, actor, reason)
+		}
+	}, nil
+}
+
+// RecordTransition records a region state that has already come about, such as one reported by its host.
+// Unexpected transitions are logged, but recorded regardless.
+func (m Manager) RecordTransition(id uuid.UUID, to string, actor string, reason string) {
+	r, ok := m.GetRegion(id)
+	if !ok {
+		return
+	}
+
+	m.lsMutex.Lock()
+	defer m.lsMutex.Unlock()
+	ls := m.stateOf(id)
+	from := ls.state
+	if from == to {
+		return
+	}
+	//the host has not caught up with a request yet
+	if to == ls.requestedFrom && time.Since(ls.requested) < requestGrace {
+		return
+	}
+	if !canTransition(from, to) {
+		m.log.Info("Region %v moved from %v to %v unexpectedly", id, from, to)
+	}
+	m.lifecycle[id] = lifecycleState{state: to}
+	m.recordTransition(r, from, to, actor, reason)
+}
+
+func (m Manager) stateOf(id uuid.UUID) lifecycleState {
+	if ls, ok := m.lifecycle[id]; ok {
+		return ls
+	}
+	return lifecycleState{state: mgm.RegionStopped}
+}
+
+func (m Manager) recordTransition(r mgm.Region, from string, to string, actor string, reason string) {
+	t := mgm.RegionTransition{
+		Region:    r.UUID,
+		Host:      r.Host,
+		From:      from,
+		To:        to,
+		Actor:     actor,
+		Reason:    reason,
+		Timestamp: time.Now(),
+	}
+	m.log.Info("Region %v %v -> %v by %v", r.UUID, from, to, actor)
+	//queued under lsMutex, so the history is written in the order transitions happened
+	m.transitions.push(t)
+}
+
+// persistTransitions writes queued transitions one at a time, the last one written is the state restored on restart
+func (m Manager) persistTransitions() {
+	for {
+		for _, t := range m.transitions.take() {
+			if err := m.mgm.RecordRegionTransition(t); err != nil {
+				m.log.Error("Error recording region transition: %v", err.Error())
+			}
+		}
+	}
+}
+
+// transitionQueue holds transitions waiting to be written.  It is unbounded, so recording a transition
+// under lsMutex never waits on the database.
+type transitionQueue struct {
+	mutex   *sync.Mutex
+	waiting *sync.Cond
+	pending []mgm.RegionTransition
+}
+
+func newTransitionQueue() *transitionQueue {
+	mutex := &sync.Mutex{}
+	return &transitionQueue{mutex: mutex, waiting: sync.NewCond(mutex)}
+}
+
+func (q *transitionQueue) push(t mgm.RegionTransition) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.pending = append(q.pending, t)
+	q.waiting.Signal()
+}
+
+// take blocks until transitions are queued, and returns all of them in the order they were pushed
+func (q *transitionQueue) take() []mgm.RegionTransition {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for len(q.pending) == 0 {
+		q.waiting.Wait()
+	}
+	pending := q.pending
+	q.pending = nil
+	return pending
+}
+
+// observedState is the lifecycle state a region stat reports, older nodes only report if it is running
+func observedState(rs mgm.RegionStat) string {
+	if rs.State != "" {
+		return rs.State
+	}
+	if rs.Running {
+		return mgm.RegionReady
+	}
+	return mgm.RegionStopped
+}
+
+// eventStates maps the region events reported by hosts to the state they leave a region in
+var eventStates = map[string]string{
+	"Ready":          mgm.RegionReady,
+	"Crashed":        mgm.RegionCrashed,
+	"Stopped":        mgm.RegionStopped,
+	"StartupTimeout": mgm.RegionFailed,
+}
+
+// GetRegionUptime reports how long a region was ready between from and until
+func (m Manager) GetRegionUptime(id uuid.UUID, from time.Time, until time.Time) (mgm.UptimeReport, error) {
+	if _, ok := m.GetRegion(id); !ok {
+		return mgm.UptimeReport{}, errors.New("Region does not exist")
+	}
+	transitions, err := m.mgm.QueryRegionTransitions(id, from, until)
+	if err != nil {
+		return mgm.UptimeReport{}, err
+	}
+	return NewUptimeReport("region", id.String(), transitions, from, until), nil
+}
+
+// NewUptimeReport summarises ordered region transitions over a period, listing each region separately
+// unless the report is for a single region
+func NewUptimeReport(subject string, id string, transitions []mgm.RegionTransition, from time.Time, until time.Time) mgm.UptimeReport {
+	//the future has no uptime yet
+	if now := time.Now(); until.After(now) {
+		until = now
+	}
+	report := mgm.UptimeReport{Subject: subject, ID: id, From: from, Until: until}
+
+	byRegion := make(map[uuid.UUID][]mgm.RegionTransition)
+	var order []uuid.UUID
+	for _, t := range transitions {
+		if _, ok := byRegion[t.Region]; !ok {
+			order = append(order, t.Region)
+		}
+		byRegion[t.Region] = append(byRegion[t.Region], t)
+	}
+
+	for _, rid := range order {
+		r := regionUptime(rid, byRegion[rid], from, until)
+		report.Ready += r.Ready
+		report.Tracked += r.Tracked
+		report.Transitions += r.Transitions
+		if subject != "region" {
+			report.Regions = append(report.Regions, r)
+		}
+	}
+	report.Availability = availability(report.Ready, report.Tracked)
+	return report
+}
+
+func regionUptime(id uuid.UUID, transitions []mgm.RegionTransition, from time.Time, until time.Time) mgm.UptimeReport {
+	r := mgm.UptimeReport{Subject: "region", ID: id.String(), From: from, Until: until}
+	state := ""
+	at := from
+	for _, t := range transitions {
+		ts := t.Timestamp
+		if ts.Before(from) {
+			//the state the period began in
+			ts = from
+		} else {
+			r.Transitions++
+		}
+		if state != "" {
+			r.Tracked += ts.Sub(at)
+			if state == mgm.RegionReady {
+				r.Ready += ts.Sub(at)
+			}
+		}
+		state, at = t.To, ts
+	}
+	if state != "" && until.After(at) {
+		r.Tracked += until.Sub(at)
+		if state == mgm.RegionReady {
+			r.Ready += until.Sub(at)
+		}
+	}
+	r.Availability = availability(r.Ready, r.Tracked)
+	return r
+}
+
+func availability(ready time.Duration, tracked time.Duration) float64 {
+	if tracked <= 0 {
+		return 0
+	}
+	return 100 * float64(ready) / float64(tracked)
+}
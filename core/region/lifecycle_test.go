@@ -0,0 +1,189 @@
+package region
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{mgm.RegionStopped, mgm.RegionStarting, true},
+		{mgm.RegionStopped, mgm.RegionReady, false},
+		{mgm.RegionStopped, mgm.RegionStopping, false},
+		{mgm.RegionStarting, mgm.RegionReady, true},
+		{mgm.RegionStarting, mgm.RegionFailed, true},
+		{mgm.RegionReady, mgm.RegionStopping, true},
+		{mgm.RegionReady, mgm.RegionStarting, false},
+		{mgm.RegionStopping, mgm.RegionStopped, true},
+		{mgm.RegionStopping, mgm.RegionStarting, false},
+		{mgm.RegionCrashed, mgm.RegionStarting, true},
+		{mgm.RegionCrashed, mgm.RegionReady, false},
+		{mgm.RegionCrashed, mgm.RegionStopping, true},
+		{mgm.RegionFailed, mgm.RegionStarting, true},
+		{mgm.RegionFailed, mgm.RegionStopping, true},
+		{mgm.RegionFailed, mgm.RegionReady, false},
+		{"unknown", mgm.RegionStarting, false},
+	}
+	for _, tt := range tests {
+		if got := canTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("canTransition(%v, %v) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestRegionUptime(t *testing.T) {
+	id := uuid.NewV4()
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := from.Add(10 * time.Hour)
+	at := func(d time.Duration, to string) mgm.RegionTransition {
+		return mgm.RegionTransition{Region: id, To: to, Timestamp: from.Add(d)}
+	}
+
+	tests := []struct {
+		name        string
+		transitions []mgm.RegionTransition
+		ready       time.Duration
+		tracked     time.Duration
+		count       int
+	}{
+		{
+			name: "no history",
+		},
+		{
+			name:        "ready since before the window",
+			transitions: []mgm.RegionTransition{at(-2*time.Hour, mgm.RegionReady)},
+			ready:       10 * time.Hour,
+			tracked:     10 * time.Hour,
+		},
+		{
+			name: "last state before the window applies",
+			transitions: []mgm.RegionTransition{
+				at(-3*time.Hour, mgm.RegionReady),
+				at(-1*time.Hour, mgm.RegionStopped),
+				at(4*time.Hour, mgm.RegionStarting),
+				at(5*time.Hour, mgm.RegionReady),
+			},
+			ready:   5 * time.Hour,
+			tracked: 10 * time.Hour,
+			count:   2,
+		},
+		{
+			name: "untracked until the first transition",
+			transitions: []mgm.RegionTransition{
+				at(2*time.Hour, mgm.RegionStarting),
+				at(3*time.Hour, mgm.RegionReady),
+				at(9*time.Hour, mgm.RegionCrashed),
+			},
+			ready:   6 * time.Hour,
+			tracked: 8 * time.Hour,
+			count:   3,
+		},
+		{
+			name: "ready across the end of the window",
+			transitions: []mgm.RegionTransition{
+				at(-time.Hour, mgm.RegionStopped),
+				at(8*time.Hour, mgm.RegionReady),
+			},
+			ready:   2 * time.Hour,
+			tracked: 10 * time.Hour,
+			count:   1,
+		},
+		{
+			name: "transition exactly at the start of the window",
+			transitions: []mgm.RegionTransition{
+				at(0, mgm.RegionReady),
+			},
+			ready:   10 * time.Hour,
+			tracked: 10 * time.Hour,
+			count:   1,
+		},
+	}
+	for _, tt := range tests {
+		r := regionUptime(id, tt.transitions, from, until)
+		if r.Ready != tt.ready || r.Tracked != tt.tracked || r.Transitions != tt.count {
+			t.Errorf("%v: got ready %v tracked %v transitions %v, want %v %v %v",
+				tt.name, r.Ready, r.Tracked, r.Transitions, tt.ready, tt.tracked, tt.count)
+		}
+		if want := availability(tt.ready, tt.tracked); r.Availability != want {
+			t.Errorf("%v: got availability %v, want %v", tt.name, r.Availability, want)
+		}
+	}
+}
+
+func TestTransitionQueue(t *testing.T) {
+	q := newTransitionQueue()
+	id := uuid.NewV4()
+	//nothing is draining the queue, pushing must not block regardless
+	for i := 0; i < 1000; i++ {
+		q.push(mgm.RegionTransition{Region: id, Reason: fmt.Sprint(i)})
+	}
+	got := q.take()
+	if len(got) != 1000 {
+		t.Fatalf("got %v transitions, want 1000", len(got))
+	}
+	for i, tr := range got {
+		if tr.Reason != fmt.Sprint(i) {
+			t.Fatalf("transition %v out of order: %v", i, tr.Reason)
+		}
+	}
+
+	done := make(chan []mgm.RegionTransition)
+	go func() { done <- q.take() }()
+	q.push(mgm.RegionTransition{Region: id, Reason: "later"})
+	select {
+	case got := <-done:
+		if len(got) != 1 || got[0].Reason != "later" {
+			t.Errorf("got %v, want the later transition", got)
+		}
+	case <-time.After(time.Second):
+		t.Error("take did not wake for a pushed transition")
+	}
+}
+
+func TestRequestTransition(t *testing.T) {
+	r := mgm.Region{UUID: uuid.NewV4()}
+	m := Manager{
+		log:         testLog{},
+		regions:     map[uuid.UUID]mgm.Region{r.UUID: r},
+		rMutex:      &sync.Mutex{},
+		lifecycle:   make(map[uuid.UUID]lifecycleState),
+		lsMutex:     &sync.Mutex{},
+		transitions: newTransitionQueue(),
+	}
+
+	undo, err := m.RequestTransition(r.UUID, mgm.RegionStarting, "admin", "Start requested")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.RequestTransition(r.UUID, mgm.RegionStarting, "admin", "Start requested"); err == nil {
+		t.Error("a second start of a starting region was accepted")
+	}
+	undo("Start failed")
+	if s := m.GetRegionState(r.UUID); s != mgm.RegionStopped {
+		t.Errorf("undone start left the region %v", s)
+	}
+
+	if _, err := m.RequestTransition(r.UUID, mgm.RegionStopping, "admin", "Stop requested"); err == nil {
+		t.Error("a stopped region was asked to stop")
+	}
+	m.RecordTransition(r.UUID, mgm.RegionReady, "host 1", "Ready")
+	if _, err := m.RequestTransition(r.UUID, mgm.RegionStopping, "admin", "Stop requested"); err != nil {
+		t.Fatal(err)
+	}
+	//a kill may follow a graceful stop
+	if _, err := m.RequestTransition(r.UUID, mgm.RegionStopping, "admin", "Kill requested"); err != nil {
+		t.Errorf("a stopping region could not be asked to stop again: %v", err)
+	}
+	if _, err := m.RequestTransition(r.UUID, mgm.RegionStarting, "admin", "Start requested"); err == nil {
+		t.Error("a stopping region was started")
+	}
+}
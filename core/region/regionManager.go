@@ -1,9 +1,12 @@
 package region
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/m-o-s-e-s/mgm/core/logger"
 	"github.com/m-o-s-e-s/mgm/core/persist"
@@ -12,10 +15,34 @@ import (
 )
 
 type notifier interface {
+	RegionUpdated(mgm.Region)
+	RegionDeleted(mgm.Region)
+	RegionStat(mgm.RegionStat)
+	RegionEvent(mgm.RegionEvent)
 }
 
+// store is the persistence regions are kept in, satisfied by persist.MGMDB
+type store interface {
+	QueryRegions() []mgm.Region
+	PersistRegion(mgm.Region)
+	PurgeRegion(uuid.UUID) error
+	QueryDefaultConfigs() []mgm.ConfigOption
+	QueryConfigs(uuid.UUID) []mgm.ConfigOption
+	RecordRegionStat(mgm.RegionStat)
+	QueryMetrics(subject string, id string, resolution time.Duration, since time.Time) ([]mgm.MetricPoint, error)
+	RecordRegionTransition(mgm.RegionTransition) error
+	QueryLastRegionStates() (map[uuid.UUID]string, error)
+	QueryRegionTransitions(region uuid.UUID, from time.Time, until time.Time) ([]mgm.RegionTransition, error)
+}
+
+// defaults for regions without an [MGM] restart policy
+const (
+	defaultMaxCrashes  = 5
+	defaultCrashWindow = 10 * time.Minute
+)
+
 // NewManager constructs a RegionManager for use
-func NewManager(mgmURL string, simianURL string, pers persist.MGMDB, osdb persist.Database, notify notifier, log logger.Log) Manager {
+func NewManager(mgmURL string, simianURL string, pers store, osdb persist.Database, notify notifier, log logger.Log) Manager {
 	rMgr := Manager{}
 	rMgr.simianURL = simianURL
 	rMgr.mgmURL = mgmURL
@@ -26,12 +53,25 @@ func NewManager(mgmURL string, simianURL string, pers persist.MGMDB, osdb persis
 	rMgr.regionStats = make(map[uuid.UUID]mgm.RegionStat)
 	rMgr.rMutex = &sync.Mutex{}
 	rMgr.rsMutex = &sync.Mutex{}
+	rMgr.lifecycle = make(map[uuid.UUID]lifecycleState)
+	rMgr.lsMutex = &sync.Mutex{}
+	rMgr.transitions = newTransitionQueue()
 	rMgr.notify = notify
 
 	for _, r := range pers.QueryRegions() {
 		rMgr.regions[r.UUID] = r
-		rMgr.regionStats[r.UUID] = mgm.RegionStat{}
+		rMgr.regionStats[r.UUID] = mgm.RegionStat{UUID: r.UUID}
+	}
+
+	//resume the lifecycle where it was left, so the event history stays continuous
+	states, err := pers.QueryLastRegionStates()
+	if err != nil {
+		rMgr.log.Error("Error loading region states: %v", err.Error())
+	}
+	for id, state := range states {
+		rMgr.lifecycle[id] = lifecycleState{state: state}
 	}
+	go rMgr.persistTransitions()
 
 	return rMgr
 }
@@ -41,13 +81,16 @@ type Manager struct {
 	simianURL   string
 	mgmURL      string
 	osdb        persist.Database
-	mgm         persist.MGMDB
+	mgm         store
 	notify      notifier
 	log         logger.Log
 	regions     map[uuid.UUID]mgm.Region
 	rMutex      *sync.Mutex
 	regionStats map[uuid.UUID]mgm.RegionStat
 	rsMutex     *sync.Mutex
+	lifecycle   map[uuid.UUID]lifecycleState
+	lsMutex     *sync.Mutex
+	transitions *transitionQueue
 }
 
 // GetRegions get a slice of all regions from cache
@@ -61,6 +104,172 @@ func (m Manager) GetRegions() []mgm.Region {
 	return t
 }
 
+// GetRegion retrieves a region record from cache
+func (m Manager) GetRegion(id uuid.UUID) (mgm.Region, bool) {
+	m.rMutex.Lock()
+	defer m.rMutex.Unlock()
+	r, ok := m.regions[id]
+	return r, ok
+}
+
+// UpdateRegion caches and persists a modified region record, notifying the client manager as well
+func (m Manager) UpdateRegion(r mgm.Region) {
+	m.CacheRegion(r)
+	m.PublishRegion(r)
+}
+
+// CacheRegion caches a modified region record, so it is seen immediately by other callers.
+// The change must be followed by PublishRegion once any caller locks are released.
+func (m Manager) CacheRegion(r mgm.Region) {
+	m.rMutex.Lock()
+	m.regions[r.UUID] = r
+	m.rMutex.Unlock()
+}
+
+// PublishRegion persists a cached region record, and notifies the client manager
+func (m Manager) PublishRegion(r mgm.Region) {
+	m.mgm.PersistRegion(r)
+	m.notify.RegionUpdated(r)
+}
+
+// maxRegionSize is the largest var-region supported, in 256m units
+const maxRegionSize = 32
+
+// CreateRegion adds a new region at a free location on the grid, generating its console credentials
+func (m Manager) CreateRegion(name string, size uint, locX uint, locY uint) (mgm.Region, error) {
+	r := mgm.Region{
+		UUID:         uuid.NewV4(),
+		Name:         strings.TrimSpace(name),
+		Size:         size,
+		LocX:         locX,
+		LocY:         locY,
+		ConsoleUname: uuid.NewV4(),
+		ConsolePass:  uuid.NewV4(),
+	}
+
+	m.rMutex.Lock()
+	err := m.validateRegion(r)
+	if err != nil {
+		m.rMutex.Unlock()
+		return r, err
+	}
+	m.regions[r.UUID] = r
+	m.rMutex.Unlock()
+
+	m.rsMutex.Lock()
+	m.regionStats[r.UUID] = mgm.RegionStat{UUID: r.UUID}
+	m.rsMutex.Unlock()
+
+	m.log.Info("Region %v (%v) created at %v,%v", r.Name, r.UUID, r.LocX, r.LocY)
+	m.mgm.PersistRegion(r)
+	m.notify.RegionUpdated(r)
+	return r, nil
+}
+
+// ModifyRegion renames, resizes or relocates a region.  The region must not be running, as opensim
+// only reads these on startup.
+func (m Manager) ModifyRegion(id uuid.UUID, name string, size uint, locX uint, locY uint) (mgm.Region, error) {
+	if !m.IsHalted(id) {
+		return mgm.Region{}, errors.New("Region must be stopped before it is modified")
+	}
+
+	m.rMutex.Lock()
+	r, ok := m.regions[id]
+	if !ok {
+		m.rMutex.Unlock()
+		return r, errors.New("Region does not exist")
+	}
+	r.Name = strings.TrimSpace(name)
+	r.Size = size
+	r.LocX = locX
+	r.LocY = locY
+	err := m.validateRegion(r)
+	if err != nil {
+		m.rMutex.Unlock()
+		return r, err
+	}
+	m.regions[id] = r
+	m.rMutex.Unlock()
+
+	m.log.Info("Region %v (%v) modified, at %v,%v size %v", r.Name, r.UUID, r.LocX, r.LocY, r.Size)
+	m.mgm.PersistRegion(r)
+	m.notify.RegionUpdated(r)
+	return r, nil
+}
+
+// DeleteRegion removes a region record and its configuration.  The region must not be running, and
+// should already have been removed from its host.
+func (m Manager) DeleteRegion(id uuid.UUID) error {
+	if !m.IsHalted(id) {
+		return errors.New("Region must be stopped before it is deleted")
+	}
+
+	m.rMutex.Lock()
+	r, ok := m.regions[id]
+	if !ok {
+		m.rMutex.Unlock()
+		return errors.New("Region does not exist")
+	}
+	delete(m.regions, id)
+	m.rMutex.Unlock()
+
+	err := m.mgm.PurgeRegion(id)
+	if err != nil {
+		//the record is still there, so is the region
+		m.rMutex.Lock()
+		m.regions[id] = r
+		m.rMutex.Unlock()
+		return err
+	}
+
+	m.rsMutex.Lock()
+	delete(m.regionStats, id)
+	m.rsMutex.Unlock()
+	m.lsMutex.Lock()
+	delete(m.lifecycle, id)
+	m.lsMutex.Unlock()
+
+	m.log.Info("Region %v (%v) deleted", r.Name, r.UUID)
+	m.notify.RegionDeleted(r)
+	return nil
+}
+
+// IsHalted tests if a region is not running, such that it may be modified or deleted
+func (m Manager) IsHalted(id uuid.UUID) bool {
+	switch m.GetRegionState(id) {
+	case mgm.RegionStopped, mgm.RegionCrashed, mgm.RegionFailed:
+		return true
+	}
+	return false
+}
+
+// validateRegion checks a region record against every other region, which must be held under rMutex.
+// Regions cover Size by Size grid cells from LocX,LocY, and may not overlap.
+func (m Manager) validateRegion(r mgm.Region) error {
+	if r.Name == "" {
+		return errors.New("Region name cannot be empty")
+	}
+	if r.Size < 1 || r.Size > maxRegionSize {
+		return fmt.Errorf("Region size must be between 1 and %v", maxRegionSize)
+	}
+	for _, o := range m.regions {
+		if o.UUID == r.UUID {
+			continue
+		}
+		if strings.EqualFold(o.Name, r.Name) {
+			return fmt.Errorf("Region name %v is already in use", r.Name)
+		}
+		size := o.Size
+		if size < 1 {
+			size = 1
+		}
+		if r.LocX < o.LocX+size && o.LocX < r.LocX+r.Size && r.LocY < o.LocY+size && o.LocY < r.LocY+r.Size {
+			return fmt.Errorf("Region would overlap %v at %v,%v", o.Name, o.LocX, o.LocY)
+		}
+	}
+	return nil
+}
+
 // GetRegionStats get a slice of all region stats from cache
 func (m Manager) GetRegionStats() []mgm.RegionStat {
 	m.rsMutex.Lock()
@@ -72,6 +281,43 @@ func (m Manager) GetRegionStats() []mgm.RegionStat {
 	return t
 }
 
+// GetRegionStat retrieves the last known stats for a region
+func (m Manager) GetRegionStat(id uuid.UUID) (mgm.RegionStat, bool) {
+	m.rsMutex.Lock()
+	defer m.rsMutex.Unlock()
+	rs, ok := m.regionStats[id]
+	return rs, ok
+}
+
+// UpdateRegionStat consume an updated region stat reported by a host, notifying the client manager as well
+func (m Manager) UpdateRegionStat(hostID int64, rs mgm.RegionStat) {
+	r, ok := m.GetRegion(rs.UUID)
+	if !ok || r.Host != hostID {
+		m.log.Info("Discarding stats for region %v not assigned to host %v", rs.UUID, hostID)
+		return
+	}
+	m.rsMutex.Lock()
+	if _, ok := m.regionStats[rs.UUID]; !ok {
+		m.rsMutex.Unlock()
+		m.log.Info("Discarding stats for unknown region %v", rs.UUID)
+		return
+	}
+	m.regionStats[rs.UUID] = rs
+	m.rsMutex.Unlock()
+	m.mgm.RecordRegionStat(rs)
+	m.notify.RegionStat(rs)
+
+	m.RecordTransition(rs.UUID, observedState(rs), fmt.Sprintf("host %v", hostID), "")
+}
+
+// GetRegionMetrics retrieves the load history of a region at a rollup resolution
+func (m Manager) GetRegionMetrics(id uuid.UUID, resolution time.Duration, since time.Time) ([]mgm.MetricPoint, error) {
+	if _, ok := m.GetRegion(id); !ok {
+		return nil, errors.New("Region does not exist")
+	}
+	return m.mgm.QueryMetrics(persist.MetricRegion, id.String(), resolution, since)
+}
+
 // GetDefaultConfigs retrieves the default region configuration
 func (m Manager) GetDefaultConfigs() []mgm.ConfigOption {
 	return m.mgm.QueryDefaultConfigs()
@@ -82,14 +328,175 @@ func (m Manager) GetConfigs(id uuid.UUID) []mgm.ConfigOption {
 	return m.mgm.QueryConfigs(id)
 }
 
+// GetHostLabels retrieves the host labels a region requires, from its [MGM] HostLabels configuration
+func (m Manager) GetHostLabels(id uuid.UUID) []string {
+	return hostLabels(m.mgm.QueryConfigs(id))
+}
+
+func hostLabels(cfgs []mgm.ConfigOption) []string {
+	var labels []string
+	for _, cfg := range cfgs {
+		if cfg.Section != "MGM" || cfg.Item != "HostLabels" {
+			continue
+		}
+		for _, l := range strings.Split(cfg.Content, ",") {
+			if l = strings.TrimSpace(l); l != "" {
+				labels = append(labels, l)
+			}
+		}
+	}
+	return labels
+}
+
+// restartPolicy derives how a region is restarted after an unexpected exit, from its [MGM] configuration.
+// RestartPolicy is one of never, on-failure or always, MaxCrashes within CrashWindow seconds disables restarts.
+func (m Manager) restartPolicy(id uuid.UUID, cfgs []mgm.ConfigOption) mgm.RestartPolicy {
+	p := mgm.RestartPolicy{
+		Mode:       mgm.RestartNever,
+		MaxCrashes: defaultMaxCrashes,
+		Window:     defaultCrashWindow,
+	}
+	for _, cfg := range cfgs {
+		if cfg.Section != "MGM" {
+			continue
+		}
+		switch cfg.Item {
+		case "RestartPolicy":
+			switch mode := strings.TrimSpace(cfg.Content); mode {
+			case mgm.RestartNever, mgm.RestartOnFailure, mgm.RestartAlways:
+				p.Mode = mode
+			default:
+				m.log.Error("Region %v has invalid RestartPolicy %v, restarts disabled", id, mode)
+			}
+		case "MaxCrashes":
+			if n, err := strconv.Atoi(strings.TrimSpace(cfg.Content)); err == nil && n > 0 {
+				p.MaxCrashes = n
+			}
+		case "CrashWindow":
+			if n, err := strconv.Atoi(strings.TrimSpace(cfg.Content)); err == nil && n > 0 {
+				p.Window = time.Duration(n) * time.Second
+			}
+		}
+	}
+	return p
+}
+
+// runtimeOverrides derives a region's launcher overrides from its [MGM] configuration.
+// Runtime names a preset, RuntimeArgs replaces the launch arguments, RuntimeExtraArgs and RuntimeEnv are appended.
+// Lists are whitespace separated.
+func runtimeOverrides(cfgs []mgm.ConfigOption) mgm.Runtime {
+	rt := mgm.Runtime{}
+	for _, cfg := range cfgs {
+		if cfg.Section != "MGM" {
+			continue
+		}
+		switch cfg.Item {
+		case "Runtime":
+			rt.Preset = strings.TrimSpace(cfg.Content)
+		case "RuntimeExecutable":
+			rt.Executable = strings.TrimSpace(cfg.Content)
+		case "RuntimeArgs":
+			rt.Args = strings.Fields(cfg.Content)
+		case "RuntimeExtraArgs":
+			rt.ExtraArgs = strings.Fields(cfg.Content)
+		case "RuntimeEnv":
+			rt.Env = strings.Fields(cfg.Content)
+		case "RuntimeWorkDir":
+			rt.WorkDir = strings.TrimSpace(cfg.Content)
+		}
+	}
+	return rt
+}
+
+// resourceLimits derives a region's resource limits from its [MGM] MemoryLimitMB, CPULimitPercent and
+// OpenFileLimit configuration.  Unset limits fall back to the host defaults.
+func resourceLimits(cfgs []mgm.ConfigOption) mgm.ResourceLimits {
+	l := mgm.ResourceLimits{}
+	for _, cfg := range cfgs {
+		if cfg.Section != "MGM" {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(cfg.Content))
+		if err != nil || n < 0 {
+			continue
+		}
+		switch cfg.Item {
+		case "MemoryLimitMB":
+			l.MemoryMB = n
+		case "CPULimitPercent":
+			l.CPUPercent = n
+		case "OpenFileLimit":
+			l.OpenFiles = n
+		}
+	}
+	return l
+}
+
+// startupTimeout derives how long a region may take to finish loading from its [MGM] StartupTimeout
+// configuration in seconds, zero leaves it to the host default
+func startupTimeout(cfgs []mgm.ConfigOption) time.Duration {
+	for _, cfg := range cfgs {
+		if cfg.Section != "MGM" || cfg.Item != "StartupTimeout" {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(cfg.Content)); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return 0
+}
+
+// RegionEvent consumes an event reported by the host running a region, notifying the client manager as well
+func (m Manager) RegionEvent(hostID int64, ev mgm.RegionEvent) {
+	r, ok := m.GetRegion(ev.UUID)
+	if !ok || r.Host != hostID {
+		m.log.Info("Discarding %v event for region %v not assigned to host %v", ev.Type, ev.UUID, hostID)
+		return
+	}
+	if ev.Type == "CrashLoop" || ev.Type == "StartupTimeout" {
+		m.log.Error("Region %v (%v): %v", r.Name, r.UUID, ev.Message)
+	} else {
+		m.log.Info("Region %v (%v) %v: %v", r.Name, r.UUID, ev.Type, ev.Message)
+	}
+	//events carry the reason for a transition, which stats alone cannot
+	if state, ok := eventStates[ev.Type]; ok {
+		m.RecordTransition(r.UUID, state, fmt.Sprintf("host %v", hostID), ev.Message)
+	}
+	m.notify.RegionEvent(ev)
+}
+
+// StartConfig is everything a host is sent to start a region, derived from a single read of its configuration
+type StartConfig struct {
+	Configs []mgm.ConfigOption
+	Restart mgm.RestartPolicy
+	Runtime mgm.Runtime
+	Limits  mgm.ResourceLimits
+	Startup time.Duration
+}
+
+// GetStartConfig reads a region's configuration once, producing the ini options and [MGM] settings to start it with
+func (m Manager) GetStartConfig(region mgm.Region, host mgm.Host) StartConfig {
+	cfgs := m.mgm.QueryConfigs(region.UUID)
+	return StartConfig{
+		Configs: m.serveConfigs(region, host, cfgs),
+		Restart: m.restartPolicy(region.UUID, cfgs),
+		Runtime: runtimeOverrides(cfgs),
+		Limits:  resourceLimits(cfgs),
+		Startup: startupTimeout(cfgs),
+	}
+}
+
 // ServeConfigs generates a list of configuration options to feed to a region before it starts
 func (m Manager) ServeConfigs(region mgm.Region, host mgm.Host) []mgm.ConfigOption {
+	return m.serveConfigs(region, host, m.mgm.QueryConfigs(region.UUID))
+}
+
+func (m Manager) serveConfigs(region mgm.Region, host mgm.Host, regionConfigs []mgm.ConfigOption) []mgm.ConfigOption {
 	var result []mgm.ConfigOption
 
 	gridURL := fmt.Sprintf("http://%v/Grid/", m.simianURL)
 
 	defaultConfigs := m.mgm.QueryDefaultConfigs()
-	regionConfigs := m.mgm.QueryConfigs(region.UUID)
 
 	configs := make(map[string]map[string]string)
 
@@ -237,6 +644,9 @@ func (m Manager) ServeConfigs(region mgm.Region, host mgm.Host) []mgm.ConfigOpti
 
 	configs["SimianGrid"]["SimianServiceURL"] = gridURL
 
+	//the MGM section is for MGM itself, opensim never sees it
+	delete(configs, "MGM")
+
 	//convert map into a single slice of ConfigOption
 	for section, m := range configs {
 		for item, content := range m {
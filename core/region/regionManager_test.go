@@ -0,0 +1,74 @@
+package region
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+func TestValidateRegion(t *testing.T) {
+	home := mgm.Region{UUID: uuid.NewV4(), Name: "Home", Size: 1, LocX: 1000, LocY: 1000}
+	east := mgm.Region{UUID: uuid.NewV4(), Name: "East", Size: 1, LocX: 1002, LocY: 1000}
+	large := mgm.Region{UUID: uuid.NewV4(), Name: "Large", Size: 4, LocX: 2000, LocY: 2000}
+	m := Manager{regions: map[uuid.UUID]mgm.Region{home.UUID: home, east.UUID: east, large.UUID: large}}
+
+	moved := home
+	moved.LocX = 1001
+
+	tests := []struct {
+		name  string
+		r     mgm.Region
+		valid bool
+	}{
+		{"empty name", mgm.Region{UUID: uuid.NewV4(), Size: 1, LocX: 10, LocY: 10}, false},
+		{"zero size", mgm.Region{UUID: uuid.NewV4(), Name: "New", LocX: 10, LocY: 10}, false},
+		{"oversized", mgm.Region{UUID: uuid.NewV4(), Name: "New", Size: maxRegionSize + 1, LocX: 10, LocY: 10}, false},
+		{"free cell", mgm.Region{UUID: uuid.NewV4(), Name: "New", Size: 1, LocX: 10, LocY: 10}, true},
+		{"name in use", mgm.Region{UUID: uuid.NewV4(), Name: "home", Size: 1, LocX: 10, LocY: 10}, false},
+		{"same cell", mgm.Region{UUID: uuid.NewV4(), Name: "New", Size: 1, LocX: 1000, LocY: 1000}, false},
+		{"inside a larger region", mgm.Region{UUID: uuid.NewV4(), Name: "New", Size: 1, LocX: 2003, LocY: 2003}, false},
+		{"larger region covering a neighbour", mgm.Region{UUID: uuid.NewV4(), Name: "New", Size: 2, LocX: 999, LocY: 999}, false},
+		{"adjacent to a larger region", mgm.Region{UUID: uuid.NewV4(), Name: "New", Size: 1, LocX: 2004, LocY: 2000}, true},
+		{"adjacent below a larger region", mgm.Region{UUID: uuid.NewV4(), Name: "New", Size: 2, LocX: 2000, LocY: 1998}, true},
+		{"diagonal neighbour", mgm.Region{UUID: uuid.NewV4(), Name: "New", Size: 1, LocX: 1001, LocY: 1001}, true},
+		{"modify keeping its own location", home, true},
+		{"modify onto a free neighbour", moved, true},
+		{"modify growing over a neighbour", mgm.Region{UUID: home.UUID, Name: "Home", Size: 3, LocX: 1000, LocY: 1000}, false},
+		{"modify growing into a larger region", mgm.Region{UUID: home.UUID, Name: "Home", Size: 2, LocX: 1999, LocY: 1999}, false},
+	}
+	for _, tt := range tests {
+		err := m.validateRegion(tt.r)
+		if (err == nil) != tt.valid {
+			t.Errorf("%v: got %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestRestartPolicy(t *testing.T) {
+	opt := func(item string, content string) mgm.ConfigOption {
+		return mgm.ConfigOption{Section: "MGM", Item: item, Content: content}
+	}
+	defaults := mgm.RestartPolicy{Mode: mgm.RestartNever, MaxCrashes: defaultMaxCrashes, Window: defaultCrashWindow}
+
+	tests := []struct {
+		name string
+		cfgs []mgm.ConfigOption
+		want mgm.RestartPolicy
+	}{
+		{"defaults", nil, defaults},
+		{"on-failure", []mgm.ConfigOption{opt("RestartPolicy", " on-failure ")},
+			mgm.RestartPolicy{Mode: mgm.RestartOnFailure, MaxCrashes: defaultMaxCrashes, Window: defaultCrashWindow}},
+		{"crash limits", []mgm.ConfigOption{opt("RestartPolicy", "always"), opt("MaxCrashes", "3"), opt("CrashWindow", "60")},
+			mgm.RestartPolicy{Mode: mgm.RestartAlways, MaxCrashes: 3, Window: time.Minute}},
+		{"invalid limits keep the defaults", []mgm.ConfigOption{opt("MaxCrashes", "0"), opt("CrashWindow", "soon")}, defaults},
+		{"other sections are ignored", []mgm.ConfigOption{{Section: "Startup", Item: "RestartPolicy", Content: "always"}}, defaults},
+	}
+	m := Manager{}
+	for _, tt := range tests {
+		if got := m.restartPolicy(uuid.NewV4(), tt.cfgs); got != tt.want {
+			t.Errorf("%v: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
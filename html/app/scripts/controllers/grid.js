@@ -25,6 +25,11 @@ angular.module('mgmApp')
       return this.section === section;
     };
 
+    //a host secret is only ever shown once, it belongs in the Secret setting of the node configuration
+    var showSecret = function (secret) {
+      alertify.alert('Place this secret in the [node] Secret setting of the node configuration, it will not be shown again:<br><code>' + secret + '</code>');
+    };
+
     $scope.host = {
       delete: function (host) {
         alertify.confirm('Are you sure you want to remove this host?', function (e) {
@@ -59,10 +64,27 @@ angular.module('mgmApp')
             response = angular.fromJson(response);
             if (response.Success === false) {
               alertify.error(response.Message);
+            } else {
+              showSecret(response.Message);
             }
           });
         });
       },
+      rotateSecret: function (host) {
+        alertify.confirm('Issue a new secret for this host? The node will not reconnect until it is configured with the new secret.', function (e) {
+          if (e) {
+            console.log('Requesting new secret for host ' + host.ID);
+            mgm.ws.emit('RotateHostSecret', '' + host.ID, function (response) {
+              response = angular.fromJson(response);
+              if (response.Success === false) {
+                alertify.error(response.Message);
+              } else {
+                showSecret(response.Message);
+              }
+            });
+          }
+        });
+      },
       countRunning: function (host) {
         var running = 0;
         for (var uuid in host.Regions) {
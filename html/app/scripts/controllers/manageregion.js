@@ -16,6 +16,7 @@ angular.module('mgmApp')
 
 
     $scope.currentHost = '';
+    $scope.forceHost = false;
     $scope.currentEstate = '';
     $scope.currentX = region.LocX;
     $scope.currentY = region.LocY;
@@ -125,7 +126,8 @@ angular.module('mgmApp')
       if ($scope.currentHost.ID !== $scope.region.Host) {
         mgm.request('SetHost', {
           'RegionUUID': region.UUID,
-          'ID': $scope.currentHost.ID
+          'ID': $scope.currentHost.ID,
+          'Force': $scope.forceHost
         }, function(success, msg){
           alertify.log('' + success +': ' + msg);
         });
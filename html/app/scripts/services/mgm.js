@@ -111,6 +111,7 @@ angular.module('mgmApp').service('mgm', function ($location, $rootScope, $q, $ht
     self.ws.on('HostRemoved', onHostRemoved);
     self.ws.on('HostStat', onHostStat);
     self.ws.on('Region', onRegion);
+    self.ws.on('RegionStat', onRegionStat);
 
     function onUser(data){
       var user = angular.fromJson(data);
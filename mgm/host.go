@@ -14,6 +14,14 @@ type Host struct {
 	Hostname        string
 	Regions         []uuid.UUID
 	Slots           int
+	Secret          string `json:"-"`
+	Labels          []string
+	Builds          []string
+
+	MinRegionPort  int
+	MaxRegionPort  int
+	MinConsolePort int
+	MaxConsolePort int
 }
 
 // Serialize implements UserObject interface Serialize function
@@ -47,12 +55,20 @@ func (h HostDeleted) ObjectType() string {
 type HostStat struct {
 	ID         int64
 	CPUPercent []float64
+	//memory and disk sizes are in kB, of 1000 bytes
 	MEMTotal   uint64
 	MEMUsed    uint64
 	MEMPercent float64
-	NetSent    uint64
-	NetRecv    uint64
-	Running    bool
+	//network and disk io are in bytes over the last HostStatInterval
+	NetSent uint64
+	NetRecv uint64
+	//disk figures are for the filesystem holding the region directories
+	DiskTotal   uint64
+	DiskUsed    uint64
+	DiskPercent float64
+	DiskRead    uint64
+	DiskWrite   uint64
+	Running     bool
 }
 
 // Serialize implements UserObject interface Serialize function
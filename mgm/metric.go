@@ -0,0 +1,14 @@
+package mgm
+
+import "time"
+
+// MetricPoint is an averaged sample of host or region load over a period starting at Time
+type MetricPoint struct {
+	Time       time.Time
+	CPUPercent float64
+	//Memory is a percentage for hosts, and KB for regions
+	Memory  float64
+	Agents  float64
+	SimFPS  float64
+	Samples int
+}
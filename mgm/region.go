@@ -19,6 +19,7 @@ type Region struct {
 	LocX         uint
 	LocY         uint
 	Host         int64
+	Version      string
 
 	frames chan int
 }
@@ -54,14 +55,15 @@ func (r *Region) countFrames() {
 // Serialize implements UserObject interface Serialize function
 func (r Region) Serialize() []byte {
 	type clientSafeRegion struct {
-		UUID uuid.UUID
-		Name string
-		Size uint
-		LocX uint
-		LocY uint
-		Host int64
+		UUID    uuid.UUID
+		Name    string
+		Size    uint
+		LocX    uint
+		LocY    uint
+		Host    int64
+		Version string
 	}
-	csr := clientSafeRegion{r.UUID, r.Name, r.Size, r.LocX, r.LocY, r.Host}
+	csr := clientSafeRegion{r.UUID, r.Name, r.Size, r.LocX, r.LocY, r.Host, r.Version}
 	data, _ := json.Marshal(csr)
 	return data
 }
@@ -71,13 +73,81 @@ func (r Region) ObjectType() string {
 	return "Region"
 }
 
+// Region process states reported in RegionStat.  A region is ready once opensim reports it has finished
+// loading, and failed when it does not within its startup timeout.
+const (
+	RegionStarting = "starting"
+	RegionReady    = "ready"
+	RegionStopping = "stopping"
+	RegionStopped  = "stopped"
+	RegionCrashed  = "crashed"
+	RegionFailed   = "failed"
+)
+
+// RegionTransition records a region moving between lifecycle states, who caused it, and why
+type RegionTransition struct {
+	Region    uuid.UUID
+	Host      int64
+	From      string
+	To        string
+	Actor     string
+	Reason    string
+	Timestamp time.Time
+}
+
+// Serialize implements UserObject interface Serialize function
+func (rt RegionTransition) Serialize() []byte {
+	data, _ := json.Marshal(rt)
+	return data
+}
+
+// ObjectType implements UserObject
+func (rt RegionTransition) ObjectType() string {
+	return "RegionTransition"
+}
+
+// UptimeReport summarises how long a region, or the regions of a host, were ready over a period.
+// Tracked is the part of the period where the region state is known.
+type UptimeReport struct {
+	Subject      string
+	ID           string
+	From         time.Time
+	Until        time.Time
+	Ready        time.Duration
+	Tracked      time.Duration
+	Availability float64
+	Transitions  int
+	Regions      []UptimeReport `json:",omitempty"`
+}
+
 // RegionStat holds region-specific runtime metrics
 type RegionStat struct {
 	UUID       uuid.UUID
 	Running    bool
+	State      string
 	CPUPercent float64
 	MemKB      float64
 	Uptime     time.Duration
+	Sim        SimStats
+	//DiskKB is the space used by the region directory, including its asset cache
+	DiskKB       uint64
+	AssetCacheKB uint64
+}
+
+// SimStats are simulator health figures reported by opensim itself
+type SimStats struct {
+	//Available is set when the simulator answered the last poll
+	Available        bool
+	SimFPS           float64
+	PhysicsFPS       float64
+	TimeDilation     float64
+	RootAgents       int
+	ChildAgents      int
+	Prims            int
+	ActivePrims      int
+	ActiveScripts    int
+	PendingDownloads int
+	PendingUploads   int
 }
 
 // Serialize implements UserObject interface Serialize function
@@ -123,3 +193,87 @@ func (rc RegionConsole) Serialize() []byte {
 func (rc RegionConsole) ObjectType() string {
 	return "RegionConsole"
 }
+
+// Rest console calls, relayed by a node to the console of a region it hosts
+const (
+	ConsoleStart   = "StartSession"
+	ConsoleRead    = "ReadResponses"
+	ConsoleCommand = "SessionCommand"
+	ConsoleClose   = "CloseSession"
+)
+
+// ConsoleCall is a rest console request and its result, tunneled between MGM and a node
+type ConsoleCall struct {
+	Call    string
+	Session string   `json:",omitempty"`
+	Command string   `json:",omitempty"`
+	Lines   []string `json:",omitempty"`
+}
+
+// ConsoleAudit records a command issued against a region console, and whether it was permitted
+type ConsoleAudit struct {
+	Region    uuid.UUID
+	User      uuid.UUID
+	Name      string
+	Command   string
+	Allowed   bool
+	Reason    string
+	Timestamp time.Time
+}
+
+// Restart policy modes, governing what a node does when a region process exits unexpectedly
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// RestartPolicy describes how a node restarts a region process that exits unexpectedly.
+// A region that crashes MaxCrashes times within Window is left down.
+type RestartPolicy struct {
+	Mode       string
+	MaxCrashes int
+	Window     time.Duration
+}
+
+// RegionEvent records a change in a region process, such as an unexpected exit
+type RegionEvent struct {
+	UUID      uuid.UUID
+	Type      string
+	Message   string
+	ExitCode  int
+	Signal    string
+	Uptime    time.Duration
+	Timestamp time.Time
+	Output    []string `json:",omitempty"`
+}
+
+// Serialize implements UserObject interface Serialize function
+func (re RegionEvent) Serialize() []byte {
+	data, _ := json.Marshal(re)
+	return data
+}
+
+// ObjectType implements UserObject
+func (re RegionEvent) ObjectType() string {
+	return "RegionEvent"
+}
+
+// Runtime describes how a region process is launched.  Empty fields inherit from the node configuration.
+type Runtime struct {
+	//Preset selects a known launcher, such as mono or dotnet
+	Preset     string   `json:",omitempty"`
+	Executable string   `json:",omitempty"`
+	Args       []string `json:",omitempty"`
+	ExtraArgs  []string `json:",omitempty"`
+	Env        []string `json:",omitempty"`
+	WorkDir    string   `json:",omitempty"`
+}
+
+// ResourceLimits are ceilings placed on a region process, zero values are unlimited
+type ResourceLimits struct {
+	MemoryMB int `json:",omitempty"`
+	//CPUPercent is relative to a single core, 200 allows two full cores
+	CPUPercent int `json:",omitempty"`
+	OpenFiles  int `json:",omitempty"`
+}
@@ -0,0 +1,123 @@
+package remote
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+)
+
+// opensim holds console reads open for up to 25 seconds while waiting on output
+var consoleClient = &http.Client{Timeout: 45 * time.Second}
+
+// consoleCommands opens a rest console session with a local region, and issues commands in order
+func consoleCommands(reg mgm.Region, cmds ...string) error {
+	session, err := consoleStart(reg)
+	if err != nil {
+		return err
+	}
+
+	for _, cmd := range cmds {
+		err = consoleCommand(reg, session, cmd)
+		if err != nil {
+			return err
+		}
+	}
+
+	//the session may already be gone if we asked the region to quit
+	consoleClose(reg, session)
+	return nil
+}
+
+// RelayConsole performs a rest console call against a local region on behalf of MGM
+func RelayConsole(reg mgm.Region, call mgm.ConsoleCall) (mgm.ConsoleCall, error) {
+	result := mgm.ConsoleCall{Call: call.Call, Session: call.Session}
+	var err error
+	switch call.Call {
+	case mgm.ConsoleStart:
+		result.Session, err = consoleStart(reg)
+	case mgm.ConsoleRead:
+		result.Lines, err = consoleRead(reg, call.Session)
+	case mgm.ConsoleCommand:
+		err = consoleCommand(reg, call.Session, call.Command)
+	case mgm.ConsoleClose:
+		err = consoleClose(reg, call.Session)
+	default:
+		err = fmt.Errorf("Unsupported console call %v", call.Call)
+	}
+	return result, err
+}
+
+func consolePost(reg mgm.Region, call string, values url.Values) ([]byte, error) {
+	u := fmt.Sprintf("http://127.0.0.1:%v/%v/", reg.ConsolePort, call)
+	resp, err := consoleClient.PostForm(u, values)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func consoleStart(reg mgm.Region) (string, error) {
+	body, err := consolePost(reg, mgm.ConsoleStart, url.Values{
+		"USER": {reg.ConsoleUname.String()},
+		"PASS": {reg.ConsolePass.String()},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	type consoleConnectXML struct {
+		XMLName   xml.Name `xml:"ConsoleSession"`
+		SessionID string   `xml:"SessionID"`
+	}
+	ss := consoleConnectXML{}
+	err = xml.Unmarshal(body, &ss)
+	if err != nil {
+		return "", err
+	}
+	if ss.SessionID == "" {
+		return "", errors.New("Console session refused")
+	}
+	return ss.SessionID, nil
+}
+
+func consoleRead(reg mgm.Region, session string) ([]string, error) {
+	body, err := consolePost(reg, mgm.ConsoleRead+"/"+session, url.Values{"ID": {session}})
+	if err != nil {
+		return nil, err
+	}
+	//an empty body means the read timed out without output
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	type consoleReadXML struct {
+		XMLName xml.Name `xml:"ConsoleSession"`
+		Lines   []string `xml:"Line"`
+	}
+	ss := consoleReadXML{}
+	err = xml.Unmarshal(body, &ss)
+	if err != nil {
+		return nil, err
+	}
+	return ss.Lines, nil
+}
+
+func consoleCommand(reg mgm.Region, session string, cmd string) error {
+	_, err := consolePost(reg, mgm.ConsoleCommand, url.Values{
+		"ID":      {session},
+		"COMMAND": {cmd},
+	})
+	return err
+}
+
+func consoleClose(reg mgm.Region, session string) error {
+	_, err := consolePost(reg, mgm.ConsoleClose, url.Values{"ID": {session}})
+	return err
+}
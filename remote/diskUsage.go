@@ -0,0 +1,26 @@
+package remote
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// assetCacheDir is where the flotsam asset cache lives, relative to a region directory
+const assetCacheDir = "assetcache"
+
+// dirSizeKB totals the files held by a directory.  Symlinks and hard links shared with
+// the opensim build are not counted, as they take no space of their own.
+func dirSizeKB(dir string) uint64 {
+	var total int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			//files come and go under a running region
+			return nil
+		}
+		if info.Mode().IsRegular() && linkCount(info) == 1 {
+			total += info.Size()
+		}
+		return nil
+	})
+	return uint64(total / 1024)
+}
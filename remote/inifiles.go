@@ -37,6 +37,8 @@ ExternalHostName = %s
 		r.hostName,
 	)
 
+	//the file may be linked to shared binaries, replace rather than write through it
+	os.Remove(regionsINI)
 	err := ioutil.WriteFile(regionsINI, []byte(content), 0644)
 	return err
 }
@@ -62,6 +64,7 @@ func (r region) WriteOpensimINI(configs []mgm.ConfigOption) error {
 		}
 	}
 
+	os.Remove(opensimINI)
 	f, err := os.Create(opensimINI)
 	if err != nil {
 		return err
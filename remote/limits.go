@@ -0,0 +1,150 @@
+package remote
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+// cgroupPeriod is the cpu accounting period used for cgroup cpu ceilings, in microseconds
+const cgroupPeriod = 100000
+
+// throttleReportInterval limits how often cpu throttling is reported for a region
+const throttleReportInterval = time.Minute
+
+// ResolveLimits layers region specific limits onto the node defaults, non-zero values win
+func ResolveLimits(base mgm.ResourceLimits, override mgm.ResourceLimits) mgm.ResourceLimits {
+	if override.MemoryMB > 0 {
+		base.MemoryMB = override.MemoryMB
+	}
+	if override.CPUPercent > 0 {
+		base.CPUPercent = override.CPUPercent
+	}
+	if override.OpenFiles > 0 {
+		base.OpenFiles = override.OpenFiles
+	}
+	return base
+}
+
+// limiter applies resource limits to a region process, and detects when they are hit
+type limiter struct {
+	limits         mgm.ResourceLimits
+	cgroup         string
+	oomKills       uint64
+	throttled      uint64
+	throttleReport time.Time
+}
+
+// newLimiter prepares limits for a region, using a cgroup v2 group beneath root when one can be created
+func newLimiter(root string, id uuid.UUID, limits mgm.ResourceLimits) (*limiter, error) {
+	l := &limiter{limits: limits}
+	if root == "" || (limits.MemoryMB == 0 && limits.CPUPercent == 0) {
+		return l, nil
+	}
+
+	//cgroup v2 exposes its controllers at the top of the hierarchy
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err != nil {
+		return l, fmt.Errorf("cgroup v2 is not available at %v, memory will be polled and cpu is unenforced", root)
+	}
+	ioutil.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("+memory +cpu"), 0644)
+
+	dir := filepath.Join(root, id.String())
+	err := os.Mkdir(dir, 0755)
+	if err != nil && !os.IsExist(err) {
+		return l, err
+	}
+	if limits.MemoryMB > 0 {
+		err = ioutil.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.Itoa(limits.MemoryMB*1024*1024)), 0644)
+		if err != nil {
+			return l, err
+		}
+	}
+	if limits.CPUPercent > 0 {
+		quota := limits.CPUPercent * cgroupPeriod / 100
+		err = ioutil.WriteFile(filepath.Join(dir, "cpu.max"), []byte(fmt.Sprintf("%v %v", quota, cgroupPeriod)), 0644)
+		if err != nil {
+			return l, err
+		}
+	}
+	l.cgroup = dir
+	//counters survive in a reused group, only new breaches are reported
+	l.oomKills = readCounter(filepath.Join(dir, "memory.events"), "oom_kill")
+	l.throttled = readCounter(filepath.Join(dir, "cpu.stat"), "nr_throttled")
+	return l, nil
+}
+
+// apply places a started process under the limits
+func (l *limiter) apply(pid int) error {
+	if l.limits.OpenFiles > 0 {
+		err := setOpenFileLimit(pid, uint64(l.limits.OpenFiles))
+		if err != nil {
+			return fmt.Errorf("Cannot limit open files: %v", err.Error())
+		}
+	}
+	if l.cgroup != "" {
+		err := ioutil.WriteFile(filepath.Join(l.cgroup, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644)
+		if err != nil {
+			l.cgroup = ""
+			return fmt.Errorf("Cannot move process into cgroup: %v", err.Error())
+		}
+	}
+	return nil
+}
+
+// check reports any limits breached since the last check.  kill is set when the
+// process must be killed because the kernel is not enforcing the limit for us.
+func (l *limiter) check(memKB float64) (breaches []string, kill bool) {
+	if l.cgroup == "" {
+		if l.limits.MemoryMB > 0 && memKB/1024 > float64(l.limits.MemoryMB) {
+			breaches = append(breaches, fmt.Sprintf("Memory use of %.0fMB exceeds the %vMB limit, killing region", memKB/1024, l.limits.MemoryMB))
+			kill = true
+		}
+		return
+	}
+
+	if n := readCounter(filepath.Join(l.cgroup, "memory.events"), "oom_kill"); n > l.oomKills {
+		breaches = append(breaches, fmt.Sprintf("Memory limit of %vMB reached, process killed by the kernel", l.limits.MemoryMB))
+		l.oomKills = n
+	}
+	if n := readCounter(filepath.Join(l.cgroup, "cpu.stat"), "nr_throttled"); n > l.throttled {
+		if time.Since(l.throttleReport) > throttleReportInterval {
+			breaches = append(breaches, fmt.Sprintf("CPU limit of %v%% reached, region is being throttled", l.limits.CPUPercent))
+			l.throttleReport = time.Now()
+		}
+		l.throttled = n
+	}
+	return
+}
+
+// release removes the region cgroup once its process has exited
+func (l *limiter) release() {
+	if l.cgroup != "" {
+		os.Remove(l.cgroup)
+	}
+}
+
+// readCounter reads a named counter from a cgroup flat keyed file, zero if unavailable
+func readCounter(file string, key string) uint64 {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			n, _ := strconv.ParseUint(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,39 @@
+package remote
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSetOpenFileLimit(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip("cannot start a process to limit:", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	if err := setOpenFileLimit(cmd.Process.Pid, 64); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(cmd.Process.Pid) + "/limits")
+	if err != nil {
+		t.Skip("process limits are not readable:", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) < 2 || fields[0] != "64" || fields[1] != "64" {
+			t.Errorf("open file limit is %v, want soft and hard of 64", fields)
+		}
+		return
+	}
+	t.Error("open file limit not found")
+}
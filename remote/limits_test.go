@@ -0,0 +1,172 @@
+package remote
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+func TestResolveLimits(t *testing.T) {
+	base := mgm.ResourceLimits{MemoryMB: 2048, CPUPercent: 100, OpenFiles: 4096}
+	tests := []struct {
+		name     string
+		override mgm.ResourceLimits
+		want     mgm.ResourceLimits
+	}{
+		{"no override", mgm.ResourceLimits{}, base},
+		{"memory override", mgm.ResourceLimits{MemoryMB: 512}, mgm.ResourceLimits{MemoryMB: 512, CPUPercent: 100, OpenFiles: 4096}},
+		{"full override", mgm.ResourceLimits{MemoryMB: 1, CPUPercent: 250, OpenFiles: 64}, mgm.ResourceLimits{MemoryMB: 1, CPUPercent: 250, OpenFiles: 64}},
+		{"negative values are ignored", mgm.ResourceLimits{MemoryMB: -1, CPUPercent: -1}, base},
+	}
+	for _, tt := range tests {
+		if got := ResolveLimits(base, tt.override); got != tt.want {
+			t.Errorf("%v: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func testCgroupRoot(t *testing.T) (string, func()) {
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpu memory"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return root, func() { os.RemoveAll(root) }
+}
+
+func readTrimmed(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestNewLimiterCgroup(t *testing.T) {
+	root, cleanup := testCgroupRoot(t)
+	defer cleanup()
+
+	tests := []struct {
+		name   string
+		limits mgm.ResourceLimits
+		memory string
+		cpu    string
+	}{
+		{"memory only", mgm.ResourceLimits{MemoryMB: 512}, "536870912", ""},
+		{"cpu only", mgm.ResourceLimits{CPUPercent: 50}, "", "50000 100000"},
+		{"two cores", mgm.ResourceLimits{MemoryMB: 1, CPUPercent: 200}, "1048576", "200000 100000"},
+	}
+	for _, tt := range tests {
+		id := uuid.NewV4()
+		l, err := newLimiter(root, id, tt.limits)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		dir := filepath.Join(root, id.String())
+		if l.cgroup != dir {
+			t.Fatalf("%v: cgroup %q, want %q", tt.name, l.cgroup, dir)
+		}
+		for file, want := range map[string]string{"memory.max": tt.memory, "cpu.max": tt.cpu} {
+			path := filepath.Join(dir, file)
+			if want == "" {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("%v: %v written without a limit", tt.name, file)
+				}
+				continue
+			}
+			if got := readTrimmed(t, path); got != want {
+				t.Errorf("%v: %v is %q, want %q", tt.name, file, got, want)
+			}
+		}
+	}
+	if got := readTrimmed(t, filepath.Join(root, "cgroup.subtree_control")); got != "+memory +cpu" {
+		t.Errorf("subtree_control is %q", got)
+	}
+}
+
+func TestNewLimiterWithoutCgroup(t *testing.T) {
+	//no limits needing a cgroup, or no cgroup root configured
+	for _, tt := range []struct {
+		root   string
+		limits mgm.ResourceLimits
+	}{
+		{"", mgm.ResourceLimits{MemoryMB: 512}},
+		{"/nonexistent", mgm.ResourceLimits{OpenFiles: 1024}},
+	} {
+		l, err := newLimiter(tt.root, uuid.NewV4(), tt.limits)
+		if err != nil || l.cgroup != "" {
+			t.Errorf("root %q: cgroup %q, %v", tt.root, l.cgroup, err)
+		}
+	}
+
+	//a root without cgroup v2 falls back to polling, with a warning
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := newLimiter(dir, uuid.NewV4(), mgm.ResourceLimits{MemoryMB: 512})
+	if err == nil || l.cgroup != "" {
+		t.Errorf("cgroup v1 root: cgroup %q, %v", l.cgroup, err)
+	}
+}
+
+func TestLimiterCheck(t *testing.T) {
+	//without a cgroup, memory is polled and the node must kill the process itself
+	l := &limiter{limits: mgm.ResourceLimits{MemoryMB: 100}}
+	if breaches, kill := l.check(100 * 1024); len(breaches) != 0 || kill {
+		t.Errorf("at the limit: %v, kill %v", breaches, kill)
+	}
+	if breaches, kill := l.check(101 * 1024); len(breaches) != 1 || !kill {
+		t.Errorf("over the limit: %v, kill %v", breaches, kill)
+	}
+
+	//with a cgroup, the kernel enforces limits and only new counter increments are reported
+	root, cleanup := testCgroupRoot(t)
+	defer cleanup()
+	id := uuid.NewV4()
+	dir := filepath.Join(root, id.String())
+	os.Mkdir(dir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "memory.events"), []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "cpu.stat"), []byte("usage_usec 100\nnr_periods 10\nnr_throttled 2\n"), 0644)
+
+	l, err := newLimiter(root, id, mgm.ResourceLimits{MemoryMB: 100, CPUPercent: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if breaches, kill := l.check(500 * 1024); len(breaches) != 0 || kill {
+		t.Errorf("reused group reported old breaches: %v, kill %v", breaches, kill)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "memory.events"), []byte("oom_kill 2\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "cpu.stat"), []byte("nr_throttled 5\n"), 0644)
+	if breaches, kill := l.check(0); len(breaches) != 2 || kill {
+		t.Errorf("new breaches: %v, kill %v", breaches, kill)
+	}
+	//throttling is reported at most once per interval
+	ioutil.WriteFile(filepath.Join(dir, "cpu.stat"), []byte("nr_throttled 9\n"), 0644)
+	if breaches, _ := l.check(0); len(breaches) != 0 {
+		t.Errorf("throttling reported again within the interval: %v", breaches)
+	}
+
+	//processes are moved into the group
+	if err := l.apply(4242); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTrimmed(t, filepath.Join(dir, "cgroup.procs")); got != "4242" {
+		t.Errorf("cgroup.procs is %q", got)
+	}
+	//release removes the group once empty
+	os.RemoveAll(dir)
+	os.Mkdir(dir, 0755)
+	l.release()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("cgroup was not released")
+	}
+}
@@ -0,0 +1,143 @@
+package remote
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/shirou/gopsutil/process"
+)
+
+// stateEntry records a running region process, so a restarted node can take it back
+type stateEntry struct {
+	Dir     string
+	PID     int
+	Started time.Time
+	Options StartOptions
+}
+
+// nodeState is the node's record of running region processes, kept on disk across node restarts
+type nodeState struct {
+	path    string
+	mutex   *sync.Mutex
+	entries map[uuid.UUID]stateEntry
+}
+
+func newNodeState(path string) *nodeState {
+	return &nodeState{
+		path:    path,
+		mutex:   &sync.Mutex{},
+		entries: make(map[uuid.UUID]stateEntry),
+	}
+}
+
+// load reads the state file, a missing file is an empty state
+func (s *nodeState) load() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, &s.entries)
+}
+
+// save writes the state file, replacing it atomically
+func (s *nodeState) save() error {
+	content, err := json.Marshal(s.entries)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// started records a region process
+func (s *nodeState) started(id uuid.UUID, dir string, pid int, start time.Time, opts StartOptions) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries[id] = stateEntry{dir, pid, start, opts}
+	return s.save()
+}
+
+// exited forgets a region process
+func (s *nodeState) exited(id uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.entries[id]; !ok {
+		return nil
+	}
+	delete(s.entries, id)
+	return s.save()
+}
+
+// get retrieves the recorded process of a region
+func (s *nodeState) get(id uuid.UUID) (stateEntry, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	e, ok := s.entries[id]
+	return e, ok
+}
+
+// verify drops any recorded process that is no longer ours, returning the regions that remain
+func (s *nodeState) verify() (map[uuid.UUID]stateEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	live := make(map[uuid.UUID]stateEntry)
+	for id, e := range s.entries {
+		if e.isLive() {
+			live[id] = e
+		}
+	}
+	s.entries = live
+	return live, s.save()
+}
+
+// isLive confirms the recorded pid is still the region process, and not a reused pid
+func (e stateEntry) isLive() bool {
+	p, err := process.NewProcess(int32(e.PID))
+	if err != nil {
+		return false
+	}
+	cmdline, err := p.Cmdline()
+	if err != nil {
+		return false
+	}
+	rt := e.Options.Runtime
+	signature := rt.Executable
+	if len(rt.Args) > 0 {
+		signature = rt.Args[0]
+	}
+	if signature == "" || !strings.Contains(cmdline, signature) {
+		return false
+	}
+	cwd, err := processDir(e.PID)
+	if err != nil {
+		return false
+	}
+	return cwd == processWorkDir(e.Dir, rt.WorkDir)
+}
+
+// processWorkDir resolves the working directory of a region process
+func processWorkDir(dir string, workDir string) string {
+	//relative working directories are within the region directory
+	if filepath.IsAbs(workDir) {
+		return workDir
+	}
+	if workDir != "" {
+		return filepath.Join(dir, workDir)
+	}
+	return dir
+}
@@ -0,0 +1,80 @@
+package remote
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+	"github.com/shirou/gopsutil/process"
+)
+
+func TestIsLive(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "islive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir, _ := filepath.EvalSymlinks(tmp)
+
+	cmd := exec.Command("sleep", "30")
+	cmd.Dir = dir
+	if err := cmd.Start(); err != nil {
+		t.Skip("cannot start a process to adopt:", err)
+	}
+	pid := cmd.Process.Pid
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	if p, err := process.NewProcess(int32(pid)); err != nil {
+		t.Skip("process inspection is unavailable:", err)
+	} else if cmdline, _ := p.Cmdline(); cmdline == "" {
+		t.Skip("process command lines are unavailable")
+	}
+
+	entry := func(pid int, dir string, rt mgm.Runtime) stateEntry {
+		return stateEntry{Dir: dir, PID: pid, Options: StartOptions{Runtime: rt}}
+	}
+	tests := []struct {
+		name string
+		e    stateEntry
+		want bool
+	}{
+		{"our process", entry(pid, dir, mgm.Runtime{Executable: "sleep"}), true},
+		{"signature from the first argument", entry(pid, dir, mgm.Runtime{Executable: "mono", Args: []string{"30"}}), true},
+		{"different executable", entry(pid, dir, mgm.Runtime{Executable: "mono", Args: []string{"OpenSim.exe"}}), false},
+		{"no signature", entry(pid, dir, mgm.Runtime{}), false},
+		{"different region directory", entry(pid, filepath.Join(dir, "other"), mgm.Runtime{Executable: "sleep"}), false},
+		{"working directory within the region", entry(pid, filepath.Dir(dir), mgm.Runtime{Executable: "sleep", WorkDir: filepath.Base(dir)}), true},
+	}
+	for _, tt := range tests {
+		if got := tt.e.isLive(); got != tt.want {
+			t.Errorf("%v: isLive %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	//verify keeps live processes and forgets the rest
+	s := newNodeState(filepath.Join(tmp, "state.json"))
+	live := uuid.NewV4()
+	s.started(live, dir, pid, time.Now(), StartOptions{Runtime: mgm.Runtime{Executable: "sleep"}})
+	s.started(uuid.NewV4(), dir, pid, time.Now(), StartOptions{Runtime: mgm.Runtime{Executable: "mono"}})
+	regions, err := s.verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := regions[live]; !ok || len(regions) != 1 {
+		t.Errorf("verify kept %v", regions)
+	}
+
+	//a process that has exited is no longer live
+	cmd.Process.Kill()
+	cmd.Wait()
+	if entry(pid, dir, mgm.Runtime{Executable: "sleep"}).isLive() {
+		t.Error("exited process is live")
+	}
+}
@@ -0,0 +1,86 @@
+package remote
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+	"github.com/satori/go.uuid"
+)
+
+func TestNodeStatePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodestate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	s := newNodeState(path)
+	if err := s.load(); err != nil {
+		t.Fatalf("missing state file: %v", err)
+	}
+
+	kept := uuid.NewV4()
+	gone := uuid.NewV4()
+	started := time.Unix(1456833600, 0)
+	opts := StartOptions{HTTPPort: 9000, Runtime: mgm.Runtime{Executable: "mono", Args: []string{"OpenSim.exe"}}}
+	if err := s.started(kept, "/regions/a", 100, started, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.started(gone, "/regions/b", 200, started, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.exited(gone); err != nil {
+		t.Fatal(err)
+	}
+	//forgetting an unknown region is harmless
+	if err := s.exited(uuid.NewV4()); err != nil {
+		t.Fatal(err)
+	}
+
+	//a restarted node reads back what was running
+	restored := newNodeState(path)
+	if err := restored.load(); err != nil {
+		t.Fatal(err)
+	}
+	e, ok := restored.get(kept)
+	if !ok {
+		t.Fatal("running region was not restored")
+	}
+	if e.Dir != "/regions/a" || e.PID != 100 || !e.Started.Equal(started) || e.Options.HTTPPort != 9000 || e.Options.Runtime.Args[0] != "OpenSim.exe" {
+		t.Errorf("restored %+v", e)
+	}
+	if _, ok := restored.get(gone); ok {
+		t.Error("exited region was restored")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary state file left behind")
+	}
+
+	//a corrupt state file is reported, not ignored
+	ioutil.WriteFile(path, []byte("{"), 0600)
+	if err := newNodeState(path).load(); err == nil {
+		t.Error("corrupt state file was loaded")
+	}
+}
+
+func TestProcessWorkDir(t *testing.T) {
+	tests := []struct {
+		dir     string
+		workDir string
+		want    string
+	}{
+		{"/regions/a", "", "/regions/a"},
+		{"/regions/a", "bin", "/regions/a/bin"},
+		{"/regions/a", "/opt/opensim", "/opt/opensim"},
+	}
+	for _, tt := range tests {
+		if got := processWorkDir(tt.dir, tt.workDir); got != tt.want {
+			t.Errorf("processWorkDir(%v, %v) = %v, want %v", tt.dir, tt.workDir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package remote
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+// setOpenFileLimit applies RLIMIT_NOFILE to a running process
+func setOpenFileLimit(pid int, n uint64) error {
+	limit := syscall.Rlimit{Cur: n, Max: n}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(syscall.RLIMIT_NOFILE), uintptr(unsafe.Pointer(&limit)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// detach starts a process in its own session, so it survives the node exiting
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// processDir reads the working directory of a running process
+func processDir(pid int) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/%v/cwd", pid))
+}
+
+// linkCount reports how many hard links share a file
+func linkCount(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
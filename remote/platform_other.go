@@ -0,0 +1,28 @@
+//go:build !linux
+// +build !linux
+
+package remote
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// setOpenFileLimit is only supported on linux
+func setOpenFileLimit(pid int, n uint64) error {
+	return errors.New("Open file limits are only supported on linux")
+}
+
+// detach is a no-op where sessions are not supported, regions exit with the node
+func detach(cmd *exec.Cmd) {}
+
+// processDir is only supported on linux, so regions are never re-adopted elsewhere
+func processDir(pid int) (string, error) {
+	return "", errors.New("Process inspection is only supported on linux")
+}
+
+// linkCount assumes files are not shared where link counts are unavailable
+func linkCount(info os.FileInfo) uint64 {
+	return 1
+}
@@ -0,0 +1,41 @@
+package remote
+
+import (
+	"strings"
+	"time"
+)
+
+// DefaultReadyMarkers are printed by opensim once a region has finished loading and accepts logins
+var DefaultReadyMarkers = []string{
+	"LOGINS ENABLED",
+	"INITIALIZATION COMPLETE FOR",
+	"Startup took",
+}
+
+// startMark prefixes the separator written into the log as each process is launched
+const startMark = "==== Starting region"
+
+// readyIn tests if output shows the most recently started process has finished loading
+func readyIn(lines []string, markers []string) bool {
+	ready := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, startMark) {
+			//anything before this belongs to an earlier process
+			ready = false
+			continue
+		}
+		for _, m := range markers {
+			if strings.Contains(line, m) {
+				ready = true
+			}
+		}
+	}
+	return ready
+}
+
+// adoptedLoading tests if a process adopted from a previous node run may still be loading.  Without a
+// startup timeout, or once past it, the process is taken as ready, as its markers may have rotated out
+// of the log and could never be found again.
+func adoptedLoading(started time.Time, timeout time.Duration, now time.Time) bool {
+	return timeout > 0 && now.Sub(started) < timeout
+}
@@ -0,0 +1,25 @@
+package remote
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdoptedLoading(t *testing.T) {
+	now := time.Now()
+	for _, tt := range []struct {
+		name    string
+		started time.Time
+		timeout time.Duration
+		want    bool
+	}{
+		{"no timeout, as in state saved before timeouts existed", now.Add(-time.Second), 0, false},
+		{"no timeout, long running", now.Add(-72 * time.Hour), 0, false},
+		{"within the timeout", now.Add(-time.Minute), 5 * time.Minute, true},
+		{"past the timeout", now.Add(-10 * time.Minute), 5 * time.Minute, false},
+	} {
+		if got := adoptedLoading(tt.started, tt.timeout, now); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package remote
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/m-o-s-e-s/mgm/core/logger"
@@ -16,13 +19,48 @@ import (
 type Region interface {
 	WriteRegionINI(mgm.Region) error
 	WriteOpensimINI([]mgm.ConfigOption) error
-	Start()
+	Start(opts StartOptions)
 	Kill()
+	Stop(reg mgm.Region, alert string, grace time.Duration) <-chan StopProgress
+	IsRunning() bool
+	Output(lines int) ([]string, error)
+	Close()
+}
+
+// StopProgress reports a stage of a graceful stop, Done is set on the final report
+type StopProgress struct {
+	Message string
+	Done    bool
+	Err     error
+}
+
+// StartOptions control how a region process is launched and supervised
+type StartOptions struct {
+	Restart mgm.RestartPolicy
+	Runtime mgm.Runtime
+	Limits  mgm.ResourceLimits
+	//CgroupRoot is the cgroup v2 group regions are placed beneath, empty disables cgroups
+	CgroupRoot string
+	//HTTPPort is polled for simulator statistics
+	HTTPPort int
+	//StartupTimeout is how long the region may take to become ready, zero waits forever
+	StartupTimeout time.Duration
+	//ReadyMarkers are output fragments showing the region has finished loading
+	ReadyMarkers []string
 }
 
 type regionCmd struct {
-	command string
-	success string
+	command  string
+	success  string
+	running  chan<- bool
+	region   mgm.Region
+	alert    string
+	grace    time.Duration
+	progress chan<- StopProgress
+	opts     StartOptions
+	gen      int
+	pid      int
+	started  time.Time
 }
 
 type region struct {
@@ -32,90 +70,394 @@ type region struct {
 	dir      string
 	hostName string
 	rStat    chan<- mgm.RegionStat
+	rEvent   chan<- mgm.RegionEvent
+	output   *ringLog
+	state    *nodeState
+	//closed once the region is no longer supervised
+	closed chan bool
 }
 
+// crashOutputLines is how much captured output accompanies a crash event
+const crashOutputLines = 20
+
+// maxRestartDelay caps the exponential backoff between automatic restarts
+const maxRestartDelay = 5 * time.Minute
+
 // NewRegion constructs a Region for use
-func NewRegion(rID uuid.UUID, path string, hostname string, rStat chan<- mgm.RegionStat, log logger.Log) Region {
+func NewRegion(rID uuid.UUID, path string, logDir string, hostname string, state *nodeState, rStat chan<- mgm.RegionStat, rEvent chan<- mgm.RegionEvent, log logger.Log) (Region, error) {
+	output, err := newRingLog(logDir)
+	if err != nil {
+		return region{}, err
+	}
 	reg := region{}
+	reg.output = &output
+	reg.state = state
 	reg.UUID = rID
 	reg.cmds = make(chan regionCmd, 8)
+	reg.closed = make(chan bool)
 	reg.log = logger.Wrap(rID.String(), log)
 	reg.dir = path
 	reg.rStat = rStat
+	reg.rEvent = rEvent
 	reg.hostName = hostname
 
 	go reg.communicate()
 
-	return reg
+	return reg, nil
 }
 
 func (r region) communicate() {
+	defer close(r.closed)
 
 	//collect region statistics
 	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
 
 	//object holding process reference
-	var exe *exec.Cmd
-	exe = nil
+	var p *os.Process
 	var start time.Time
 	var proc *process.Process
 
 	//process communication
-	terminated := make(chan bool)
+	terminated := make(chan *os.ProcessState)
+	//closed when the current process exits
+	var exited chan bool
+
+	//restart state, halting is set when we are the reason the process exits
+	var opts StartOptions
+	var lim *limiter
+
+	//simulator statistics are polled in the background, a slow simulator must not stall us
+	var sim mgm.SimStats
+	simResults := make(chan mgm.SimStats, 1)
+	polling := false
+
+	//directory sizes are walked in the background, and less often
+	diskTicker := time.NewTicker(time.Minute)
+	defer diskTicker.Stop()
+	type diskUsage struct {
+		total, cache uint64
+	}
+	var disk diskUsage
+	diskResults := make(chan diskUsage, 1)
+	measure := func() {
+		go func() {
+			diskResults <- diskUsage{dirSizeKB(r.dir), dirSizeKB(filepath.Join(r.dir, assetCacheDir))}
+		}()
+	}
+	measure()
+	//lifecycle state, readiness is found by following the process output
+	phase := mgm.RegionStopped
+	readyTicker := time.NewTicker(time.Second)
+	defer readyTicker.Stop()
+	var watchFrom int64
+	var readyBy time.Time
+
+	var halting bool
+	var crashes []time.Time
+	//bumped on every start and halt, so stale scheduled restarts are ignored
+	restartGen := 0
+	var restartTimer *time.Timer
+
+	//cancelRestart leaves a crashed or failed region stopped, so it is not restarted behind an operator's back.
+	//The restart generation must already have been bumped, which voids a restart that has already fired.
+	cancelRestart := func() bool {
+		if phase != mgm.RegionCrashed && phase != mgm.RegionFailed {
+			return false
+		}
+		if restartTimer != nil {
+			restartTimer.Stop()
+		}
+		phase = mgm.RegionStopped
+		crashes = nil
+		r.event(mgm.RegionEvent{
+			UUID:      r.UUID,
+			Type:      "Stopped",
+			Message:   "Pending restart cancelled",
+			Timestamp: time.Now(),
+		})
+		return true
+	}
+
+	//track places a running process under our supervision
+	track := func(pid int, started time.Time) {
+		halting = false
+		start = started
+		proc, _ = process.NewProcess(int32(pid))
+		//a limiter is returned even on error, enforcing what it can
+		var err error
+		lim, err = newLimiter(opts.CgroupRoot, r.UUID, opts.Limits)
+		if err != nil {
+			r.log.Error("Resource limits not fully applied: %v", err.Error())
+			r.output.Mark(err.Error())
+		}
+		if err = lim.apply(pid); err != nil {
+			r.log.Error("Resource limits not fully applied: %v", err.Error())
+			r.output.Mark(err.Error())
+		}
+		exited = make(chan bool)
+		if err = r.state.started(r.UUID, r.dir, pid, start, opts); err != nil {
+			r.log.Error("Error recording process in node state: %v", err.Error())
+		}
+	}
+
+	launch := func() {
+		//execute binaries
+		rt := opts.Runtime
+		cmd := exec.Command(rt.Executable, append(append([]string{}, rt.Args...), rt.ExtraArgs...)...)
+		cmd.Dir = processWorkDir(r.dir, rt.WorkDir)
+		cmd.Env = append(os.Environ(), rt.Env...)
+		r.output.Mark(fmt.Sprintf("Starting region at %v", time.Now().Format(time.RFC3339)))
+		watchFrom = r.output.End()
+		//the process writes straight to its log, and runs in its own session, so it outlives the node
+		out, err := r.output.File()
+		if err == nil {
+			cmd.Stdout = out
+			cmd.Stderr = out
+			detach(cmd)
+			err = cmd.Start()
+		}
+		if err != nil {
+			errMsg := fmt.Sprintf("Error starting process: %s", err.Error())
+			r.log.Error(errMsg)
+			r.output.Mark(errMsg)
+			phase = mgm.RegionFailed
+			return
+		}
+		r.log.Info("Started Successfully")
+		p = cmd.Process
+		track(p.Pid, time.Now())
+		phase = mgm.RegionStarting
+		readyBy = start.Add(opts.StartupTimeout)
+		go func(cmd *exec.Cmd, exited chan bool) {
+			//wait for process, ignoring process-specific errors
+			_ = cmd.Wait()
+			r.log.Error("Terminated")
+			close(exited)
+			terminated <- cmd.ProcessState
+		}(cmd, exited)
+	}
+
+	//adopt supervises a process started by a previous run of the node, which we cannot wait on
+	adopt := func(pid int, started time.Time) {
+		found, err := os.FindProcess(pid)
+		if err != nil {
+			return
+		}
+		r.log.Info("Adopted running process %v", pid)
+		r.output.Mark(fmt.Sprintf("Adopted by node at %v", time.Now().Format(time.RFC3339)))
+		p = found
+		track(pid, started)
+		//a process without a startup timeout, or past it, finished loading under our predecessor
+		phase = mgm.RegionReady
+		if adoptedLoading(started, opts.StartupTimeout, time.Now()) {
+			phase = mgm.RegionStarting
+			readyBy = started.Add(opts.StartupTimeout)
+			watchFrom = 0
+		}
+		go func(p *os.Process, exited chan bool) {
+			for p.Signal(syscall.Signal(0)) == nil {
+				time.Sleep(time.Second)
+			}
+			r.log.Error("Terminated")
+			close(exited)
+			//the exit status went to our predecessor
+			terminated <- nil
+		}(p, exited)
+	}
 
 	for {
 		select {
-		case <-terminated:
+		case <-readyTicker.C:
+			if phase != mgm.RegionStarting || p == nil {
+				continue
+			}
+			lines, next, err := r.output.ReadFrom(watchFrom)
+			if err != nil {
+				r.log.Error("Error following region output: %v", err.Error())
+			}
+			watchFrom = next
+			if readyIn(lines, opts.ReadyMarkers) {
+				phase = mgm.RegionReady
+				r.event(mgm.RegionEvent{
+					UUID:      r.UUID,
+					Type:      "Ready",
+					Message:   fmt.Sprintf("Region ready after %v", time.Since(start)),
+					Uptime:    time.Since(start),
+					Timestamp: time.Now(),
+				})
+				continue
+			}
+			if opts.StartupTimeout == 0 || time.Now().Before(readyBy) {
+				continue
+			}
+			//a region that never finishes loading is killed, and left down
+			phase = mgm.RegionFailed
+			ev := mgm.RegionEvent{
+				UUID:      r.UUID,
+				Type:      "StartupTimeout",
+				Message:   fmt.Sprintf("Region did not become ready within %v, killing it", opts.StartupTimeout),
+				Uptime:    time.Since(start),
+				Timestamp: time.Now(),
+			}
+			if lines, err := r.output.Tail(crashOutputLines); err == nil {
+				ev.Output = lines
+			}
+			r.event(ev)
+			r.output.Mark(ev.Message)
+			restartGen++
+			halting = true
+			p.Kill()
+		case <-diskTicker.C:
+			measure()
+		case d := <-diskResults:
+			disk = d
+		case s := <-simResults:
+			polling = false
+			if p != nil {
+				sim = s
+			}
+		case state := <-terminated:
 			//the process exited for some Reason
-			exe = nil
+			p = nil
+			sim = mgm.SimStats{}
+			r.state.exited(r.UUID)
+			//the kernel may have killed it for exceeding a limit
+			r.limitBreaches(lim, 0)
+			lim.release()
+			ev := exitEvent(r.UUID, state, time.Since(start))
+			if halting {
+				//a region killed for failing to start stays failed
+				if phase != mgm.RegionFailed {
+					phase = mgm.RegionStopped
+				}
+				ev.Type = "Stopped"
+				r.event(ev)
+				continue
+			}
+			phase = mgm.RegionCrashed
+			ev.Type = "Crashed"
+			//the last words of the process are usually why it died
+			if lines, err := r.output.Tail(crashOutputLines); err == nil {
+				ev.Output = lines
+			}
+			r.event(ev)
+
+			if !shouldRestart(opts.Restart, state != nil && state.Success()) {
+				continue
+			}
+
+			//crash-loop breaker, only crashes within the window count
+			now := time.Now()
+			crashes = recentCrashes(crashes, now, opts.Restart.Window)
+			if len(crashes) >= opts.Restart.MaxCrashes {
+				r.event(mgm.RegionEvent{
+					UUID:      r.UUID,
+					Type:      "CrashLoop",
+					Message:   fmt.Sprintf("Region exited %v times within %v, automatic restarts disabled", len(crashes), opts.Restart.Window),
+					Timestamp: now,
+				})
+				crashes = nil
+				continue
+			}
+
+			delay := restartDelay(len(crashes))
+			r.event(mgm.RegionEvent{
+				UUID:      r.UUID,
+				Type:      "Restarting",
+				Message:   fmt.Sprintf("Restarting in %v", delay),
+				Timestamp: now,
+			})
+			gen := restartGen
+			restartTimer = time.AfterFunc(delay, func() {
+				r.send(regionCmd{command: "restart", gen: gen})
+			})
 		case cmd := <-r.cmds:
 			switch cmd.command {
 			case "start":
 				//if already running, exit
-				if exe != nil {
+				if p != nil {
 					r.log.Error("Region is already running", r.UUID)
 					continue
 				}
-				//execute binaries
-				os.Chdir(r.dir)
-				cmdName := "/usr/bin/mono"
-				cmdArgs := []string{"OpenSim.exe", "-console", "rest"}
-				exe = exec.Command(cmdName, cmdArgs...)
-				err := exe.Start()
-				if err != nil {
-					errMsg := fmt.Sprintf("Error starting process: %s", err.Error())
-					r.log.Error(errMsg)
+				restartGen++
+				opts = cmd.opts
+				crashes = nil
+				launch()
+			case "adopt":
+				if p != nil {
 					continue
 				}
-				r.log.Info("Started Successfully")
-				start = time.Now()
-				proc, _ = process.NewProcess(int32(exe.Process.Pid))
-				go func() {
-					//wait for process, ignoring process-specific errors
-					_ = exe.Wait()
-					r.log.Error("Terminated")
-					exe = nil
-					terminated <- true
-				}()
+				restartGen++
+				opts = cmd.opts
+				adopt(cmd.pid, cmd.started)
+			case "restart":
+				//an operator may have started or halted the region while we waited
+				if cmd.gen != restartGen || p != nil {
+					continue
+				}
+				r.log.Info("Restarting after unexpected exit")
+				launch()
 			case "kill":
-				//if not running, exit
-				if exe == nil {
-					errMsg := fmt.Sprintf("Kill region %v failed, region is not running", r.UUID.String())
-					r.log.Error(errMsg)
+				restartGen++
+				//if not running, only a pending restart is left to cancel
+				if p == nil {
+					if !cancelRestart() {
+						r.log.Error("Kill region %v failed, region is not running", r.UUID.String())
+					}
 					continue
 				}
-				if err := exe.Process.Kill(); err != nil {
+				halting = true
+				phase = mgm.RegionStopping
+				if err := p.Kill(); err != nil {
 					errMsg := fmt.Sprintf("Error killing process: %s", err.Error())
 					r.log.Error(errMsg)
 				}
+			case "stop":
+				restartGen++
+				//if not running, only a pending restart is left to cancel
+				if p == nil {
+					if cancelRestart() {
+						cmd.progress <- StopProgress{Done: true, Message: "Pending restart cancelled"}
+					} else {
+						cmd.progress <- StopProgress{Done: true, Err: fmt.Errorf("Stop region %v failed, region is not running", r.UUID.String())}
+					}
+					close(cmd.progress)
+					continue
+				}
+				halting = true
+				phase = mgm.RegionStopping
+				go r.stop(p, exited, cmd)
+			case "status":
+				cmd.running <- p != nil
+			case "close":
+				//nothing may outlive the region, not a scheduled restart nor its process
+				restartGen++
+				if restartTimer != nil {
+					restartTimer.Stop()
+				}
+				if p != nil {
+					halting = true
+					if err := p.Kill(); err != nil {
+						r.log.Error("Error killing process: %s", err.Error())
+					}
+					<-terminated
+					r.state.exited(r.UUID)
+					lim.release()
+				}
+				r.log.Info("Region closed")
+				return
 			default:
 				r.log.Info("Received unexpected command: %v", cmd.command)
 			}
 		case <-ticker.C:
-			stat := mgm.RegionStat{UUID: r.UUID}
-			if exe == nil {
+			if err := r.output.Rotate(); err != nil {
+				r.log.Error("Error rotating output log: %v", err.Error())
+			}
+			stat := mgm.RegionStat{UUID: r.UUID, State: phase, DiskKB: disk.total, AssetCacheKB: disk.cache}
+			if p == nil {
 				//trivially halted if we never started
-				r.rStat <- stat
+				r.stat(stat)
 				continue
 			}
 			stat.Running = true
@@ -138,18 +480,212 @@ func (r region) communicate() {
 
 			elapsed := time.Since(start)
 			stat.Uptime = elapsed
+			stat.Sim = sim
+
+			if !polling && opts.HTTPPort != 0 {
+				polling = true
+				go func(port int) {
+					//a simulator that is still starting will not answer, which is reported as unavailable
+					s, _ := fetchSimStats(port)
+					simResults <- s
+				}(opts.HTTPPort)
+			}
+
+			if r.limitBreaches(lim, stat.MemKB) {
+				r.log.Error("Killing region for exceeding its resource limits")
+				p.Kill()
+			}
 
-			r.rStat <- stat
+			r.stat(stat)
 		}
 	}
 }
 
-func (r region) Start() {
-	cmd := regionCmd{command: "start"}
-	r.cmds <- cmd
+// shouldRestart tests if a restart policy calls for restarting a process that has exited
+func shouldRestart(policy mgm.RestartPolicy, success bool) bool {
+	return policy.Mode == mgm.RestartAlways || (policy.Mode == mgm.RestartOnFailure && !success)
+}
+
+// recentCrashes adds a crash at now to those that happened within the window before it
+func recentCrashes(crashes []time.Time, now time.Time, window time.Duration) []time.Time {
+	recent := []time.Time{}
+	for _, t := range crashes {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	return append(recent, now)
+}
+
+// restartDelay doubles the wait after each recent crash, starting at a second, up to maxRestartDelay
+func restartDelay(crashes int) time.Duration {
+	if crashes < 1 {
+		crashes = 1
+	}
+	if crashes > 10 {
+		return maxRestartDelay
+	}
+	delay := time.Second << uint(crashes-1)
+	if delay > maxRestartDelay {
+		delay = maxRestartDelay
+	}
+	return delay
+}
+
+// send passes a command to the region, failing once the region is closed
+func (r region) send(cmd regionCmd) bool {
+	select {
+	case r.cmds <- cmd:
+		return true
+	case <-r.closed:
+		return false
+	}
+}
+
+func (r region) Start(opts StartOptions) {
+	r.send(regionCmd{command: "start", opts: opts})
 }
 
 func (r region) Kill() {
-	cmd := regionCmd{command: "kill"}
-	r.cmds <- cmd
+	r.send(regionCmd{command: "kill"})
+}
+
+// Close ends supervision of the region, cancelling any scheduled restart and killing its process.
+// It returns once the process has exited, after which the region directory may be removed.
+func (r region) Close() {
+	if r.send(regionCmd{command: "close"}) {
+		<-r.closed
+	}
+}
+
+// adopt places a process left running by a previous node under supervision
+func (r region) adopt(pid int, started time.Time, opts StartOptions) {
+	r.send(regionCmd{command: "adopt", pid: pid, started: started, opts: opts})
+}
+
+func (r region) IsRunning() bool {
+	ch := make(chan bool, 1)
+	if !r.send(regionCmd{command: "status", running: ch}) {
+		return false
+	}
+	select {
+	case running := <-ch:
+		return running
+	case <-r.closed:
+		return false
+	}
+}
+
+// Output retrieves the last lines of output captured from the region process
+func (r region) Output(lines int) ([]string, error) {
+	return r.output.Tail(lines)
+}
+
+func (r region) Stop(reg mgm.Region, alert string, grace time.Duration) <-chan StopProgress {
+	ch := make(chan StopProgress, 8)
+	if !r.send(regionCmd{command: "stop", region: reg, alert: alert, grace: grace, progress: ch}) {
+		ch <- StopProgress{Done: true, Err: errors.New("Region has been removed")}
+		close(ch)
+	}
+	return ch
+}
+
+// stop escalates from a console quit, to SIGTERM, to SIGKILL, until the process exits
+func (r region) stop(p *os.Process, exited <-chan bool, cmd regionCmd) {
+	defer close(cmd.progress)
+	report := func(msg string) {
+		r.log.Info(msg)
+		cmd.progress <- StopProgress{Message: msg}
+	}
+	waitExit := func() bool {
+		select {
+		case <-exited:
+			return true
+		case <-time.After(cmd.grace):
+			return false
+		}
+	}
+
+	var cmds []string
+	if cmd.alert != "" {
+		report("Alerting region")
+		cmds = append(cmds, "alert "+cmd.alert)
+	}
+	report("Requesting quit over console")
+	err := consoleCommands(cmd.region, append(cmds, "quit")...)
+	if err != nil {
+		report(fmt.Sprintf("Console unavailable: %v", err.Error()))
+	} else if waitExit() {
+		cmd.progress <- StopProgress{Message: "Region stopped", Done: true}
+		return
+	}
+
+	report("Region did not exit, sending SIGTERM")
+	p.Signal(syscall.SIGTERM)
+	if waitExit() {
+		cmd.progress <- StopProgress{Message: "Region terminated", Done: true}
+		return
+	}
+
+	report("Region did not exit, sending SIGKILL")
+	p.Kill()
+	if waitExit() {
+		cmd.progress <- StopProgress{Message: "Region killed", Done: true}
+		return
+	}
+	cmd.progress <- StopProgress{Done: true, Err: errors.New("Region did not exit after SIGKILL")}
+}
+
+// event forwards a region event without stalling the region, events are dropped while the node cannot deliver them
+func (r region) event(ev mgm.RegionEvent) {
+	r.log.Info("%v: %v", ev.Type, ev.Message)
+	select {
+	case r.rEvent <- ev:
+	default:
+		r.log.Error("Event queue full, dropping %v event", ev.Type)
+	}
+}
+
+// stat forwards a region stat without stalling the region.  Stats are periodic, so while the node
+// cannot deliver them they are dropped, and the next one supersedes it.
+func (r region) stat(stat mgm.RegionStat) {
+	select {
+	case r.rStat <- stat:
+	default:
+	}
+}
+
+// exitEvent describes how and when a region process exited
+func exitEvent(id uuid.UUID, state *os.ProcessState, uptime time.Duration) mgm.RegionEvent {
+	ev := mgm.RegionEvent{UUID: id, Uptime: uptime, Timestamp: time.Now()}
+	if state == nil {
+		ev.Message = "Process exited, status unavailable"
+		return ev
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		ev.ExitCode = -1
+		ev.Signal = ws.Signal().String()
+		ev.Message = fmt.Sprintf("Process killed by %v after %v", ev.Signal, uptime)
+		return ev
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok {
+		ev.ExitCode = ws.ExitStatus()
+	}
+	ev.Message = fmt.Sprintf("Process exited with code %v after %v", ev.ExitCode, uptime)
+	return ev
+}
+
+// limitBreaches reports any resource limits the region has hit, returning true if it must be killed
+func (r region) limitBreaches(lim *limiter, memKB float64) bool {
+	breaches, kill := lim.check(memKB)
+	for _, b := range breaches {
+		r.output.Mark(b)
+		r.event(mgm.RegionEvent{
+			UUID:      r.UUID,
+			Type:      "LimitExceeded",
+			Message:   b,
+			Timestamp: time.Now(),
+		})
+	}
+	return kill
 }
@@ -0,0 +1,198 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// size limits for captured region output, a region keeps at most logKeep files of logMaxSize bytes
+const (
+	logMaxSize = 1024 * 1024
+	logKeep    = 3
+)
+
+// ringLog captures region process output into a bounded set of rotated files.  The process writes
+// to the live file directly, so that it may outlive the node, which leaves rotation to copy the file
+// aside and truncate it in place.
+type ringLog struct {
+	dir   string
+	mutex *sync.Mutex
+	file  *os.File
+}
+
+func newRingLog(dir string) (ringLog, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return ringLog{}, err
+	}
+	l := ringLog{dir: dir, mutex: &sync.Mutex{}}
+	return l, nil
+}
+
+func (l *ringLog) path(generation int) string {
+	if generation == 0 {
+		return filepath.Join(l.dir, "output.log")
+	}
+	return filepath.Join(l.dir, fmt.Sprintf("output.log.%v", generation))
+}
+
+// File opens the live log for appending, to be handed to a region process as its output
+func (l *ringLog) File() (*os.File, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.open()
+}
+
+func (l *ringLog) open() (*os.File, error) {
+	if l.file == nil {
+		f, err := os.OpenFile(l.path(0), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		l.file = f
+	}
+	return l.file, nil
+}
+
+// Write appends to the live log
+func (l *ringLog) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	f, err := l.open()
+	if err != nil {
+		return 0, err
+	}
+	return f.Write(p)
+}
+
+// Mark writes a separator into the log, so process runs can be told apart
+func (l *ringLog) Mark(msg string) {
+	l.Write([]byte(fmt.Sprintf("==== %v ====\n", msg)))
+}
+
+// End is the current length of the live log, from where ReadFrom may follow it
+func (l *ringLog) End() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	info, err := os.Stat(l.path(0))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// ReadFrom returns the complete lines written to the live log since offset, and the offset following them.
+// A log truncated by rotation is followed again from its start.
+func (l *ringLog) ReadFrom(offset int64) ([]string, int64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	f, err := os.Open(l.path(0))
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, offset, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, offset, err
+	}
+	if info.Size() < offset {
+		offset = 0
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(f, logMaxSize))
+	if err != nil {
+		return nil, offset, err
+	}
+	//a partial line is left for the next read
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return nil, offset, nil
+	}
+	return strings.Split(string(data[:end]), "\n"), offset + int64(end) + 1, nil
+}
+
+// Rotate moves the live log aside once it grows too large.  As with logrotate's copytruncate, output the
+// process writes after the copy reaches the end of the file, and before the truncate, is lost.  Renaming
+// the file instead would leave the process writing to the rotated file, as its descriptor is not ours to reopen.
+func (l *ringLog) Rotate() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	info, err := os.Stat(l.path(0))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil || info.Size() < logMaxSize {
+		return err
+	}
+
+	for i := logKeep - 1; i > 1; i-- {
+		os.Rename(l.path(i-1), l.path(i))
+	}
+	src, err := os.Open(l.path(0))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(l.path(1))
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	//truncated straight after the copy reaches the end, to keep the window for lost output small.
+	//writers append, so they continue at the start of the truncated file
+	err = os.Truncate(l.path(0), 0)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Tail retrieves the last n lines of captured output, across rotated files
+func (l *ringLog) Tail(n int) ([]string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	lines := []string{}
+	for i := logKeep - 1; i >= 0; i-- {
+		f, err := os.Open(l.path(i))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+			//only hold what we may return
+			if len(lines) > 2*n {
+				lines = lines[len(lines)-n:]
+			}
+		}
+		f.Close()
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
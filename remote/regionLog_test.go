@@ -0,0 +1,155 @@
+package remote
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testRingLog(t *testing.T) (*ringLog, func()) {
+	dir, err := ioutil.TempDir("", "ringlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := newRingLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &l, func() {
+		if l.file != nil {
+			l.file.Close()
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRingLogReadFrom(t *testing.T) {
+	l, cleanup := testRingLog(t)
+	defer cleanup()
+
+	lines, offset, err := l.ReadFrom(0)
+	if err != nil || lines != nil || offset != 0 {
+		t.Fatalf("empty log: got %v %v %v", lines, offset, err)
+	}
+
+	l.Write([]byte("one\ntwo\nthr"))
+	lines, offset, err = l.ReadFrom(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"one", "two"}) || offset != 8 {
+		t.Fatalf("partial line: got %v at %v", lines, offset)
+	}
+
+	//nothing complete yet, the offset holds
+	lines, next, err := l.ReadFrom(offset)
+	if err != nil || lines != nil || next != offset {
+		t.Fatalf("incomplete line: got %v at %v, %v", lines, next, err)
+	}
+
+	l.Write([]byte("ee\n"))
+	lines, offset, err = l.ReadFrom(offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"three"}) || offset != l.End() {
+		t.Fatalf("completed line: got %v at %v, end %v", lines, offset, l.End())
+	}
+
+	//a log truncated beneath the offset is followed from its start
+	if err = os.Truncate(l.path(0), 0); err != nil {
+		t.Fatal(err)
+	}
+	l.Write([]byte("four\n"))
+	lines, offset, err = l.ReadFrom(offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"four"}) || offset != 5 {
+		t.Fatalf("after truncate: got %v at %v", lines, offset)
+	}
+}
+
+func TestRingLogMarkAndTail(t *testing.T) {
+	l, cleanup := testRingLog(t)
+	defer cleanup()
+
+	lines, err := l.Tail(10)
+	if err != nil || len(lines) != 0 {
+		t.Fatalf("empty log: got %v, %v", lines, err)
+	}
+
+	l.Write([]byte("a\nb\nc\n"))
+	l.Mark("Starting region")
+	lines, err = l.Tail(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"c", "==== Starting region ===="}) {
+		t.Fatalf("tail: got %v", lines)
+	}
+	lines, err = l.Tail(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("short log: got %v", lines)
+	}
+}
+
+func TestRingLogRotate(t *testing.T) {
+	l, cleanup := testRingLog(t)
+	defer cleanup()
+
+	//a small log is left in place
+	l.Write([]byte("small\n"))
+	if err := l.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(l.path(1)); !os.IsNotExist(err) {
+		t.Fatal("small log was rotated")
+	}
+
+	line := strings.Repeat("x", 1023) + "\n"
+	fill := func(generation string) {
+		l.Write([]byte(generation + "\n"))
+		for written := 0; written < logMaxSize; written += len(line) {
+			l.Write([]byte(line))
+		}
+		if err := l.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fill("first")
+	if l.End() != 0 {
+		t.Fatalf("live log was not truncated, %v bytes remain", l.End())
+	}
+	//the process keeps appending to the same descriptor after a rotation
+	l.Write([]byte("after\n"))
+	lines, err := l.Tail(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{strings.TrimSuffix(line, "\n"), "after"}) {
+		t.Fatalf("tail across rotation: got %v", lines)
+	}
+
+	fill("second")
+	fill("third")
+	//only logKeep files are kept, the oldest generation is gone
+	if _, err := os.Stat(l.path(logKeep)); !os.IsNotExist(err) {
+		t.Fatal("more than logKeep files kept")
+	}
+	for i, want := range map[int]string{1: "third", 2: "after\nsecond"} {
+		data, err := ioutil.ReadFile(l.path(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(data), want+"\n") {
+			t.Errorf("generation %v does not start with %v", i, want)
+		}
+	}
+}
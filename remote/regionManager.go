@@ -2,6 +2,7 @@ package remote
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -17,97 +18,291 @@ import (
 // RegionManager interfaces with the region management objects
 type RegionManager interface {
 	Initialize() error
-	AddRegion(uuid.UUID) (Region, error)
+	AddRegion(id uuid.UUID, build string) (Region, error)
 	RemoveRegion(uuid.UUID) error
+	PurgeOrphans([]uuid.UUID) error
+	Restore() (map[uuid.UUID]Region, error)
+}
+
+// Builds are the named opensim distributions installed on a node
+type Builds struct {
+	//Dirs maps build names to their bin directories
+	Dirs map[string]string
+	//Default is used for regions that are not pinned to a build
+	Default string
+}
+
+// buildMarker records which build a region directory was provisioned from
+const buildMarker = ".mgmbuild"
+
+// Provisioning modes for region directories
+const (
+	ProvisionCopy     = "copy"
+	ProvisionHardlink = "hardlink"
+	ProvisionSymlink  = "symlink"
+)
+
+// Provisioning controls how region directories are built from the opensim binaries
+type Provisioning struct {
+	//Mode is one of copy, hardlink or symlink.  Writable files are always copied
+	Mode string
+	//Keep preserves region directories across node restarts
+	Keep bool
+}
+
+// writableDirs are opensim directories a region writes into, these are never shared between regions
+var writableDirs = map[string]bool{
+	"Regions":        true,
+	"ScriptEngines":  true,
+	"assetcache":     true,
+	"j2kDecodeCache": true,
+	"maptiles":       true,
+	"bakes":          true,
+	"estate":         true,
+}
+
+// writableExts are file types a region may modify in place
+var writableExts = map[string]bool{
+	".ini":    true,
+	".log":    true,
+	".db":     true,
+	".sqlite": true,
 }
 
 // NewRegionManager constructs a region manager for use
-func NewRegionManager(binDir string, regionDir string, hostname string, rStat chan<- mgm.RegionStat, log logger.Log) RegionManager {
+func NewRegionManager(builds Builds, regionDir string, logDir string, statePath string, prov Provisioning, hostname string, rStat chan<- mgm.RegionStat, rEvent chan<- mgm.RegionEvent, log logger.Log) RegionManager {
 	return regMgr{
-		copyFrom:  binDir,
+		builds:    builds,
 		regionDir: regionDir,
+		prov:      prov,
+		logDir:    logDir,
+		state:     newNodeState(statePath),
 		hostName:  hostname,
 		rStat:     rStat,
+		rEvent:    rEvent,
 		logger:    logger.Wrap("Region", log),
 	}
 }
 
 type regMgr struct {
-	copyFrom  string
+	builds    Builds
 	regionDir string
+	logDir    string
+	state     *nodeState
+	prov      Provisioning
 	logger    logger.Log
 	hostName  string
 	rStat     chan<- mgm.RegionStat
+	rEvent    chan<- mgm.RegionEvent
 	regions   []mgm.Region
 }
 
-func (rm regMgr) AddRegion(rID uuid.UUID) (Region, error) {
-	path, err := rm.copyBinaries(rID.String())
+func (rm regMgr) AddRegion(rID uuid.UUID, build string) (Region, error) {
+	if build == "" {
+		build = rm.builds.Default
+	}
+	binDir, ok := rm.builds.Dirs[build]
+	if !ok {
+		return region{}, fmt.Errorf("OpenSim build %v is not installed on this host", build)
+	}
+
+	path := filepath.Join(rm.regionDir, rID.String())
+	if rm.prov.Keep && rm.provisionedBuild(path) == build {
+		//kept from a previous run, reuse it as is
+		rm.logger.Info("Reusing region directory %v", path)
+		return rm.newRegion(rID, path)
+	}
+
+	//anything left over was built from another build, or is not wanted
+	rm.purgeBinaries(rID.String())
+	path, err := rm.provisionBinaries(binDir, rID.String())
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(path, buildMarker), []byte(build), 0600)
+	}
 	if err != nil {
+		rm.purgeBinaries(rID.String())
+		return region{}, err
+	}
+	rm.logger.Info("Provisioned region %v from build %v", rID, build)
+	return rm.newRegion(rID, path)
+}
+
+// provisionedBuild reads which build a region directory holds, empty if it does not exist
+func (rm regMgr) provisionedBuild(path string) string {
+	content, err := ioutil.ReadFile(filepath.Join(path, buildMarker))
+	if os.IsNotExist(err) {
+		if _, err := os.Stat(path); err == nil {
+			//directories from before builds were tracked came from the default build
+			return rm.builds.Default
+		}
+	}
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+func (rm regMgr) newRegion(rID uuid.UUID, path string) (Region, error) {
+	reg, err := NewRegion(rID, path, filepath.Join(rm.logDir, rID.String()), rm.hostName, rm.state, rm.rStat, rm.rEvent, rm.logger)
+	if err != nil {
+		rm.purgeBinaries(rID.String())
 		return region{}, err
 	}
-	reg := NewRegion(rID, path, rm.hostName, rm.rStat, rm.logger)
 
 	return reg, nil
 }
 
 func (rm regMgr) RemoveRegion(rID uuid.UUID) error {
-	return rm.purgeBinaries(rID.String())
+	rm.state.exited(rID)
+	err := rm.purgeBinaries(rID.String())
+	if err != nil {
+		return err
+	}
+	//captured output is kept across node restarts, but not once MGM removes the region
+	return os.RemoveAll(filepath.Join(rm.logDir, rID.String()))
 }
 
-func (rm regMgr) copyBinaries(name string) (string, error) {
+// provisionBinaries builds a region directory from the opensim binaries.  Immutable files are linked
+// when the provisioning mode allows it, anything a region may write to is copied.
+func (rm regMgr) provisionBinaries(copyFrom string, name string) (string, error) {
 	copyTo := filepath.Join(rm.regionDir, name)
 	err := os.Mkdir(copyTo, 0700)
 	if err != nil {
 		return "", err
 	}
-	err = filepath.Walk(rm.copyFrom, func(path string, info os.FileInfo, err error) error {
-		if path == rm.copyFrom {
+	err = filepath.Walk(copyFrom, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == copyFrom {
 			return nil
 		}
+		dst := strings.Replace(path, copyFrom, copyTo, 1)
 
 		if info.IsDir() {
-			return os.Mkdir(strings.Replace(path, rm.copyFrom, copyTo, 1), 0700)
-		}
-		src, err := os.Open(path)
-		if err != nil {
-			return err
+			return os.Mkdir(dst, 0700)
 		}
-		defer src.Close()
 
-		dst, err := os.Create(strings.Replace(path, rm.copyFrom, copyTo, 1))
-		if err != nil {
-			return err
+		rel, _ := filepath.Rel(copyFrom, path)
+		if rm.prov.Mode == ProvisionCopy || rm.prov.Mode == "" || isWritable(rel) {
+			return copyFile(path, dst)
 		}
-		if _, err := io.Copy(dst, src); err != nil {
-			dst.Close()
-			return err
+		if rm.prov.Mode == ProvisionSymlink {
+			return os.Symlink(path, dst)
 		}
-		return dst.Close()
+		//hardlinks cannot cross filesystems, fall back to a copy
+		if os.Link(path, dst) != nil {
+			return copyFile(path, dst)
+		}
+		return nil
 	})
 	return copyTo, err
 }
 
+// isWritable tests if a file, relative to the binaries directory, may be modified by a running region
+func isWritable(rel string) bool {
+	if writableExts[strings.ToLower(filepath.Ext(rel))] {
+		return true
+	}
+	top := strings.Split(filepath.ToSlash(rel), "/")[0]
+	//mono keeps its addin registry beside the binaries
+	return writableDirs[top] || strings.HasPrefix(top, "addin-db-")
+}
+
+func copyFile(from string, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
 func (rm regMgr) purgeBinaries(name string) error {
 	return os.RemoveAll(path.Join(rm.regionDir, name))
 }
 
+// PurgeOrphans removes region directories that do not belong to any of the listed regions
+func (rm regMgr) PurgeOrphans(keep []uuid.UUID) error {
+	known := make(map[string]bool)
+	for _, id := range keep {
+		known[id.String()] = true
+	}
+
+	files, err := ioutil.ReadDir(rm.regionDir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if known[f.Name()] {
+			continue
+		}
+		rm.logger.Info("Purging orphaned region directory %v", f.Name())
+		err = rm.purgeBinaries(f.Name())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (rm regMgr) Initialize() error {
 	//confirm binaries are present
-	if _, err := os.Stat(path.Join(rm.copyFrom, "OpenSim.exe")); os.IsNotExist(err) {
-		return errors.New("Opensim source directory does not exist")
+	if _, ok := rm.builds.Dirs[rm.builds.Default]; !ok {
+		return fmt.Errorf("Default build %v is not configured", rm.builds.Default)
+	}
+	for name, dir := range rm.builds.Dirs {
+		//mono builds ship OpenSim.exe, .NET builds OpenSim.dll
+		_, exeErr := os.Stat(path.Join(dir, "OpenSim.exe"))
+		_, dllErr := os.Stat(path.Join(dir, "OpenSim.dll"))
+		if os.IsNotExist(exeErr) && os.IsNotExist(dllErr) {
+			return fmt.Errorf("Opensim source directory for build %v does not exist", name)
+		}
 	}
 	//confirm regions directory exists
 	if _, err := os.Stat(rm.regionDir); os.IsNotExist(err) {
 		return errors.New("Regions directory does not exist")
 	}
+	err := os.MkdirAll(rm.logDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	//regions still running from a previous run are re-adopted, whether or not directories are kept
+	err = rm.state.load()
+	if err != nil {
+		rm.logger.Error("Ignoring unreadable node state: %v", err.Error())
+	}
+	live, err := rm.state.verify()
+	if err != nil {
+		return err
+	}
+
+	if rm.prov.Keep {
+		//kept directories are picked up by Restore
+		return nil
+	}
 
 	files, err := ioutil.ReadDir(rm.regionDir)
 	if err != nil {
 		return err
 	}
-	rm.logger.Info("Purging %v old region record(s)", len(files))
+	rm.logger.Info("Purging old region records, %v running region(s) will be adopted", len(live))
 	for _, f := range files {
+		if id, err := uuid.FromString(f.Name()); err == nil {
+			if _, ok := live[id]; ok {
+				continue
+			}
+		}
 		err = rm.purgeBinaries(f.Name())
 		if err != nil {
 			return err
@@ -116,3 +311,31 @@ func (rm regMgr) Initialize() error {
 
 	return nil
 }
+
+// Restore loads region directories that survived Initialize, re-adopting any region processes still running
+func (rm regMgr) Restore() (map[uuid.UUID]Region, error) {
+	regions := make(map[uuid.UUID]Region)
+
+	files, err := ioutil.ReadDir(rm.regionDir)
+	if err != nil {
+		return regions, err
+	}
+	for _, f := range files {
+		id, err := uuid.FromString(f.Name())
+		if err != nil || !f.IsDir() {
+			rm.logger.Info("Purging unrecognized region directory %v", f.Name())
+			rm.purgeBinaries(f.Name())
+			continue
+		}
+		reg, err := rm.newRegion(id, filepath.Join(rm.regionDir, f.Name()))
+		if err != nil {
+			return regions, err
+		}
+		if e, ok := rm.state.get(id); ok {
+			reg.(region).adopt(e.PID, e.Started, e.Options)
+		}
+		regions[id] = reg
+	}
+	rm.logger.Info("Restored %v region directories", len(regions))
+	return regions, nil
+}
@@ -0,0 +1,96 @@
+package remote
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsWritable(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"OpenSim.exe", false},
+		{"OpenSim.Framework.dll", false},
+		{"OpenSim.ini", true},
+		{"OpenSim.INI", true},
+		{"config-include/GridCommon.ini", true},
+		{"OpenSim.log", true},
+		{"Asset.db", true},
+		{"estate.sqlite", true},
+		{"Regions/Regions.ini", true},
+		{"Regions/readme.txt", true},
+		{"ScriptEngines/state.xml", true},
+		{"assetcache/00/asset", true},
+		{"maptiles/tile.jpg", true},
+		{"addin-db-002/addins.config", true},
+		{"addins-registry/addins.config", false},
+		{"lib/Regions/helper.dll", false},
+		{"RegionsExtra/helper.dll", false},
+	}
+	for _, tt := range tests {
+		if got := isWritable(filepath.FromSlash(tt.rel)); got != tt.want {
+			t.Errorf("isWritable(%v) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestProvisionBinaries(t *testing.T) {
+	root, err := ioutil.TempDir("", "provision")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	bin := filepath.Join(root, "bin")
+	for _, f := range []string{"OpenSim.exe", "OpenSim.ini", "Regions/Regions.ini", "lib/helper.dll"} {
+		p := filepath.Join(bin, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(f), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, mode := range []string{"", ProvisionCopy, ProvisionHardlink, ProvisionSymlink} {
+		regionDir := filepath.Join(root, "regions-"+mode)
+		if err := os.Mkdir(regionDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		rm := regMgr{regionDir: regionDir, prov: Provisioning{Mode: mode}}
+		dir, err := rm.provisionBinaries(bin, "region")
+		if err != nil {
+			t.Fatalf("mode %q: %v", mode, err)
+		}
+
+		for _, f := range []string{"OpenSim.exe", "OpenSim.ini", "Regions/Regions.ini", "lib/helper.dll"} {
+			src := filepath.Join(bin, filepath.FromSlash(f))
+			dst := filepath.Join(dir, filepath.FromSlash(f))
+			data, err := ioutil.ReadFile(dst)
+			if err != nil || string(data) != f {
+				t.Errorf("mode %q: %v not provisioned: %v", mode, f, err)
+				continue
+			}
+			lst, err := os.Lstat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srcInfo, _ := os.Stat(src)
+			dstInfo, _ := os.Stat(dst)
+			symlink := lst.Mode()&os.ModeSymlink != 0
+			shared := symlink || os.SameFile(srcInfo, dstInfo)
+
+			//writable files are always private to the region
+			wantShared := !isWritable(filepath.FromSlash(f)) && (mode == ProvisionHardlink || mode == ProvisionSymlink)
+			if shared != wantShared {
+				t.Errorf("mode %q: %v shared %v, want %v", mode, f, shared, wantShared)
+			}
+			if symlink != (wantShared && mode == ProvisionSymlink) {
+				t.Errorf("mode %q: %v symlinked %v", mode, f, symlink)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package remote
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+)
+
+func TestShouldRestart(t *testing.T) {
+	tests := []struct {
+		mode    string
+		success bool
+		want    bool
+	}{
+		{mgm.RestartNever, false, false},
+		{mgm.RestartNever, true, false},
+		{mgm.RestartOnFailure, false, true},
+		{mgm.RestartOnFailure, true, false},
+		{mgm.RestartAlways, false, true},
+		{mgm.RestartAlways, true, true},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		if got := shouldRestart(mgm.RestartPolicy{Mode: tt.mode}, tt.success); got != tt.want {
+			t.Errorf("shouldRestart(%q, success %v) = %v, want %v", tt.mode, tt.success, got, tt.want)
+		}
+	}
+}
+
+func TestRestartDelay(t *testing.T) {
+	tests := []struct {
+		crashes int
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{9, 256 * time.Second},
+		{10, maxRestartDelay},
+		{64, maxRestartDelay},
+	}
+	for _, tt := range tests {
+		if got := restartDelay(tt.crashes); got != tt.want {
+			t.Errorf("restartDelay(%v) = %v, want %v", tt.crashes, got, tt.want)
+		}
+	}
+}
+
+func TestCrashLoopBreaker(t *testing.T) {
+	policy := mgm.RestartPolicy{Mode: mgm.RestartAlways, MaxCrashes: 3, Window: time.Minute}
+	start := time.Now()
+
+	//crashes spread wider than the window never trip the breaker
+	var crashes []time.Time
+	for i := 0; i < 10; i++ {
+		crashes = recentCrashes(crashes, start.Add(time.Duration(i)*policy.Window), policy.Window)
+		if len(crashes) >= policy.MaxCrashes {
+			t.Fatalf("breaker tripped by crash %v spaced a window apart", i+1)
+		}
+	}
+
+	//crashes within the window trip it on the MaxCrashes'th
+	crashes = nil
+	for i := 1; i <= policy.MaxCrashes; i++ {
+		crashes = recentCrashes(crashes, start.Add(time.Duration(i)*time.Second), policy.Window)
+		tripped := len(crashes) >= policy.MaxCrashes
+		if tripped != (i == policy.MaxCrashes) {
+			t.Fatalf("crash %v: tripped %v", i, tripped)
+		}
+	}
+
+	//crashes a full window old age out, leaving the third and the new one
+	crashes = recentCrashes(crashes, start.Add(policy.Window+2*time.Second), policy.Window)
+	if len(crashes) != 2 {
+		t.Errorf("got %v recent crashes, want 2", len(crashes))
+	}
+}
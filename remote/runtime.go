@@ -0,0 +1,50 @@
+package remote
+
+import (
+	"fmt"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+)
+
+// runtimePresets are the launchers for known opensim distributions
+var runtimePresets = map[string]mgm.Runtime{
+	"mono": {
+		Executable: "/usr/bin/mono",
+		Args:       []string{"OpenSim.exe", "-console", "rest"},
+	},
+	"dotnet": {
+		Executable: "/usr/bin/dotnet",
+		Args:       []string{"OpenSim.dll", "-console", "rest"},
+	},
+}
+
+// DefaultRuntime is the launcher used when a node does not configure one
+var DefaultRuntime = runtimePresets["mono"]
+
+// ResolveRuntime layers an override onto a base runtime.  A preset replaces the executable and arguments,
+// explicit fields replace those, extra arguments and environment variables are appended.
+func ResolveRuntime(base mgm.Runtime, override mgm.Runtime) (mgm.Runtime, error) {
+	result := base
+	result.Preset = ""
+	if override.Preset != "" {
+		p, ok := runtimePresets[override.Preset]
+		if !ok {
+			return base, fmt.Errorf("Unknown runtime preset %v", override.Preset)
+		}
+		result.Executable = p.Executable
+		result.Args = p.Args
+	}
+	if override.Executable != "" {
+		result.Executable = override.Executable
+	}
+	if len(override.Args) > 0 {
+		result.Args = override.Args
+	}
+	if override.WorkDir != "" {
+		result.WorkDir = override.WorkDir
+	}
+	result.ExtraArgs = append(append([]string{}, base.ExtraArgs...), override.ExtraArgs...)
+	//later entries win when a variable is repeated
+	result.Env = append(append([]string{}, base.Env...), override.Env...)
+	return result, nil
+}
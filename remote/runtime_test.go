@@ -0,0 +1,96 @@
+package remote
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+)
+
+func TestResolveRuntime(t *testing.T) {
+	base := mgm.Runtime{
+		Executable: "/opt/mono/bin/mono",
+		Args:       []string{"OpenSim.exe", "-console", "rest"},
+		ExtraArgs:  []string{"--debug"},
+		Env:        []string{"MONO_THREADS_PER_CPU=50"},
+		WorkDir:    "/opt/opensim",
+	}
+
+	tests := []struct {
+		name     string
+		override mgm.Runtime
+		want     mgm.Runtime
+		fail     bool
+	}{
+		{
+			name:     "no override keeps the base",
+			override: mgm.Runtime{},
+			want: mgm.Runtime{
+				Executable: "/opt/mono/bin/mono",
+				Args:       []string{"OpenSim.exe", "-console", "rest"},
+				ExtraArgs:  []string{"--debug"},
+				Env:        []string{"MONO_THREADS_PER_CPU=50"},
+				WorkDir:    "/opt/opensim",
+			},
+		},
+		{
+			name:     "preset replaces executable and arguments",
+			override: mgm.Runtime{Preset: "dotnet"},
+			want: mgm.Runtime{
+				Executable: "/usr/bin/dotnet",
+				Args:       []string{"OpenSim.dll", "-console", "rest"},
+				ExtraArgs:  []string{"--debug"},
+				Env:        []string{"MONO_THREADS_PER_CPU=50"},
+				WorkDir:    "/opt/opensim",
+			},
+		},
+		{
+			name:     "explicit fields win over a preset",
+			override: mgm.Runtime{Preset: "dotnet", Executable: "/usr/local/bin/dotnet", Args: []string{"Custom.dll"}, WorkDir: "/srv"},
+			want: mgm.Runtime{
+				Executable: "/usr/local/bin/dotnet",
+				Args:       []string{"Custom.dll"},
+				ExtraArgs:  []string{"--debug"},
+				Env:        []string{"MONO_THREADS_PER_CPU=50"},
+				WorkDir:    "/srv",
+			},
+		},
+		{
+			name:     "extra arguments and environment are appended",
+			override: mgm.Runtime{ExtraArgs: []string{"-inifile=Region.ini"}, Env: []string{"MONO_THREADS_PER_CPU=100"}},
+			want: mgm.Runtime{
+				Executable: "/opt/mono/bin/mono",
+				Args:       []string{"OpenSim.exe", "-console", "rest"},
+				ExtraArgs:  []string{"--debug", "-inifile=Region.ini"},
+				Env:        []string{"MONO_THREADS_PER_CPU=50", "MONO_THREADS_PER_CPU=100"},
+				WorkDir:    "/opt/opensim",
+			},
+		},
+		{
+			name:     "unknown preset",
+			override: mgm.Runtime{Preset: "wine"},
+			fail:     true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := ResolveRuntime(base, tt.override)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%v: got %+v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	//appending must never write through to the base runtime
+	if len(base.ExtraArgs) != 1 || len(base.Env) != 1 {
+		t.Errorf("base runtime was modified: %+v", base)
+	}
+}
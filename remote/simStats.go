@@ -0,0 +1,57 @@
+package remote
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+)
+
+// simStatsClient bounds how long a busy simulator may take to answer a poll
+var simStatsClient = &http.Client{Timeout: 3 * time.Second}
+
+// fetchSimStats polls a local region's jsonSimStats endpoint, enabled by Startup.Stats_URI
+func fetchSimStats(httpPort int) (mgm.SimStats, error) {
+	stats := mgm.SimStats{}
+	resp, err := simStatsClient.Get(fmt.Sprintf("http://127.0.0.1:%v/jsonSimStats/", httpPort))
+	if err != nil {
+		return stats, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return stats, fmt.Errorf("jsonSimStats returned %v", resp.Status)
+	}
+
+	//opensim formats most figures as strings
+	raw := make(map[string]interface{})
+	err = json.NewDecoder(resp.Body).Decode(&raw)
+	if err != nil {
+		return stats, err
+	}
+	value := func(key string) float64 {
+		switch v := raw[key].(type) {
+		case float64:
+			return v
+		case string:
+			f, _ := strconv.ParseFloat(v, 64)
+			return f
+		}
+		return 0
+	}
+
+	stats.Available = true
+	stats.SimFPS = value("SimFPS")
+	stats.PhysicsFPS = value("PhyFPS")
+	stats.TimeDilation = value("Dilatn")
+	stats.RootAgents = int(value("RootAg"))
+	stats.ChildAgents = int(value("ChldAg"))
+	stats.Prims = int(value("Prims"))
+	stats.ActivePrims = int(value("AtvPrm"))
+	stats.ActiveScripts = int(value("AtvScr"))
+	stats.PendingDownloads = int(value("PendDl"))
+	stats.PendingUploads = int(value("PendUl"))
+	return stats, nil
+}
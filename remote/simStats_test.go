@@ -0,0 +1,104 @@
+package remote
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/m-o-s-e-s/mgm/mgm"
+)
+
+func serveSimStats(t *testing.T, status int, body string) (int, func()) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/jsonSimStats/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(u.Host)
+	p, _ := strconv.Atoi(port)
+	return p, srv.Close
+}
+
+func TestFetchSimStats(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   mgm.SimStats
+		fail   bool
+	}{
+		{
+			name:   "opensim string figures",
+			status: http.StatusOK,
+			body: `{"Dilatn":"0.98","SimFPS":"55","PhyFPS":"44.5","RootAg":"3","ChldAg":"1","Prims":"1200",` +
+				`"AtvPrm":"7","AtvScr":"42","PendDl":"2","PendUl":"1","Version":"OpenSim 0.8"}`,
+			want: mgm.SimStats{Available: true, SimFPS: 55, PhysicsFPS: 44.5, TimeDilation: 0.98, RootAgents: 3, ChildAgents: 1,
+				Prims: 1200, ActivePrims: 7, ActiveScripts: 42, PendingDownloads: 2, PendingUploads: 1},
+		},
+		{
+			name:   "numeric figures",
+			status: http.StatusOK,
+			body:   `{"SimFPS":45,"RootAg":2}`,
+			want:   mgm.SimStats{Available: true, SimFPS: 45, RootAgents: 2},
+		},
+		{
+			name:   "missing and malformed figures read as zero",
+			status: http.StatusOK,
+			body:   `{"SimFPS":"fast","Prims":null}`,
+			want:   mgm.SimStats{Available: true},
+		},
+		{
+			name:   "stats disabled",
+			status: http.StatusNotFound,
+			body:   "",
+			fail:   true,
+		},
+		{
+			name:   "invalid json",
+			status: http.StatusOK,
+			body:   "<html>",
+			fail:   true,
+		},
+	}
+	for _, tt := range tests {
+		port, stop := serveSimStats(t, tt.status, tt.body)
+		got, err := fetchSimStats(port)
+		stop()
+		if tt.fail {
+			if err == nil || got.Available {
+				t.Errorf("%v: got %+v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%v: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFetchSimStatsUnavailable(t *testing.T) {
+	//a region that is not listening yet
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	if s, err := fetchSimStats(port); err == nil || s.Available {
+		t.Errorf("closed port: got %+v, %v", s, err)
+	}
+}
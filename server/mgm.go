@@ -80,6 +80,12 @@ func main() {
 
 	//instantiate our persistance handler
 	pers := persist.NewMGMDB(db, osdb, sim, logger)
+	//columns and tables added since the original schema are created here, before anything reads them
+	err = pers.UpgradeSchema()
+	if err != nil {
+		logger.Error("Upgrading database schema: ", err)
+		return
+	}
 
 	//create our client notifier
 	notifier := client.NewNotifier()
@@ -88,10 +94,25 @@ func main() {
 	//Hook up core processing...
 	jMgr := job.NewManager(config.Web.FileStorage, config.MGM.MgmURL, config.MGM.HubRegionUUID, pers, notifier, logger)
 	rMgr := region.NewManager(config.MGM.MgmURL, config.MGM.SimianURL, pers, osdb, notifier, logger)
-	hMgr := host.NewManager(config.MGM.NodePort, rMgr, pers, notifier, logger)
+	policy, err := host.NewPlacementPolicy(config.MGM.PlacementPolicy)
+	if err != nil {
+		logger.Fatal("Error in config file: ", err)
+		return
+	}
+	hMgr := host.NewManager(config.MGM.NodePort, config.MGM.MissedHostStats, policy, rMgr, pers, notifier, logger)
 	uMgr := user.NewManager(rMgr, hMgr, jMgr, sim, pers, notifier, logger)
 
-	cMgr := client.NewManager(uMgr, hMgr, rMgr, jMgr, notifier, logger)
+	var rules []region.ConsoleRule
+	for _, p := range config.ConsolePolicy {
+		rules = append(rules, region.ConsoleRule{Roles: p.Role, AccessLevel: p.AccessLevel, Allow: p.Allow, Deny: p.Deny})
+	}
+	consolePolicy, err := region.NewConsolePolicy(rules)
+	if err != nil {
+		logger.Fatal("Error in config file: ", err)
+		return
+	}
+	consoles := region.NewConsoleHub(hMgr, consolePolicy, pers, logger)
+	cMgr := client.NewManager(uMgr, hMgr, rMgr, jMgr, consoles, notifier, logger)
 
 	// http function handler
 	httpCon := webClient.NewHTTPConnector(jMgr, pers, sim, uMgr, mailer, logger)
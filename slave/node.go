@@ -5,6 +5,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"code.google.com/p/gcfg"
@@ -18,6 +21,7 @@ import (
 	"github.com/m-o-s-e-s/mgm/mgm"
 	"github.com/m-o-s-e-s/mgm/remote"
 	pscpu "github.com/shirou/gopsutil/cpu"
+	psdisk "github.com/shirou/gopsutil/disk"
 	psmem "github.com/shirou/gopsutil/mem"
 	psnet "github.com/shirou/gopsutil/net"
 )
@@ -25,8 +29,15 @@ import (
 type nodeConfig struct {
 	Node struct {
 		OpensimBinDir string
+		DefaultBuild  string
 		RegionDir     string
 		MGMAddress    string
+		Secret        string
+		LogDir        string
+		StateFile     string
+		Provisioning  string
+		KeepRegions   bool
+		Label         []string
 	}
 
 	Opensim struct {
@@ -35,7 +46,48 @@ type nodeConfig struct {
 		MinConsolePort  uint
 		MaxConsolePort  uint
 		ExternalAddress string
+		StopGracePeriod uint
+		StartupTimeout  uint
+		ReadyMarker     []string
 	}
+
+	Build map[string]*struct {
+		BinDir string
+	}
+
+	Limits struct {
+		MemoryMB   int
+		CPUPercent int
+		OpenFiles  int
+		CgroupRoot string
+	}
+
+	Runtime struct {
+		Preset     string
+		Executable string
+		Arg        []string
+		ExtraArg   []string
+		Env        []string
+		WorkDir    string
+	}
+}
+
+// defaultBuildName names the build in OpensimBinDir
+const defaultBuildName = "default"
+
+// configuredBuilds collects the opensim builds this node can provision regions from
+func configuredBuilds(config nodeConfig) remote.Builds {
+	builds := remote.Builds{Dirs: make(map[string]string), Default: config.Node.DefaultBuild}
+	for name, b := range config.Build {
+		builds.Dirs[name] = b.BinDir
+	}
+	if config.Node.OpensimBinDir != "" {
+		builds.Dirs[defaultBuildName] = config.Node.OpensimBinDir
+	}
+	if builds.Default == "" {
+		builds.Default = defaultBuildName
+	}
+	return builds
 }
 
 type mgmNode struct {
@@ -44,7 +96,6 @@ type mgmNode struct {
 
 func main() {
 	n := mgmNode{lumber.NewConsoleLogger(lumber.DEBUG)}
-	connectedAtLeastOnce := false
 
 	cfgPtr := flag.String("config", "/opt/mgm/node.gcfg", "path to config file")
 	flag.Parse()
@@ -70,18 +121,73 @@ func main() {
 	}
 
 	n.logger.Info("config loaded successfully")
-	regions := map[uuid.UUID]remote.Region{}
+
+	//the node launcher, which regions may override
+	runtime, err := remote.ResolveRuntime(remote.DefaultRuntime, mgm.Runtime{
+		Preset:     config.Runtime.Preset,
+		Executable: config.Runtime.Executable,
+		Args:       config.Runtime.Arg,
+		ExtraArgs:  config.Runtime.ExtraArg,
+		Env:        config.Runtime.Env,
+		WorkDir:    config.Runtime.WorkDir,
+	})
+	if err != nil {
+		n.logger.Fatal("Error in config file: ", err.Error())
+		return
+	}
+
+	//default ceilings for every region, which regions may override
+	limits := mgm.ResourceLimits{
+		MemoryMB:   config.Limits.MemoryMB,
+		CPUPercent: config.Limits.CPUPercent,
+		OpenFiles:  config.Limits.OpenFiles,
+	}
+
+	//how long a region is given to exit at each stage of a graceful stop
+	grace := time.Duration(config.Opensim.StopGracePeriod) * time.Second
+	if grace == 0 {
+		grace = 30 * time.Second
+	}
+
+	//how long a region may take to finish loading before it is failed, regions may override
+	startupTimeout := time.Duration(config.Opensim.StartupTimeout) * time.Second
+	if startupTimeout == 0 {
+		startupTimeout = 5 * time.Minute
+	}
+	readyMarkers := config.Opensim.ReadyMarker
+	if len(readyMarkers) == 0 {
+		readyMarkers = remote.DefaultReadyMarkers
+	}
 
 	hStats := make(chan mgm.HostStat, 8)
-	go n.collectHostStatistics(hStats)
+	go n.collectHostStatistics(hStats, config.Node.RegionDir)
 	rStats := make(chan mgm.RegionStat, 64)
+	rEvents := make(chan mgm.RegionEvent, 64)
 
-	rMgr := remote.NewRegionManager(config.Node.OpensimBinDir, config.Node.RegionDir, config.Opensim.ExternalAddress, rStats, n.logger)
+	//captured region output lives outside of the region directory, which is purged on startup
+	logDir := config.Node.LogDir
+	if logDir == "" {
+		logDir = filepath.Join(filepath.Dir(filepath.Clean(config.Node.RegionDir)), "regionLogs")
+	}
+	//running region processes are recorded here, so they can be re-adopted after a node restart
+	statePath := config.Node.StateFile
+	if statePath == "" {
+		statePath = filepath.Join(filepath.Dir(filepath.Clean(config.Node.RegionDir)), "node.state")
+	}
+
+	builds := configuredBuilds(config)
+	rMgr := remote.NewRegionManager(builds, config.Node.RegionDir, logDir, statePath, remote.Provisioning{Mode: config.Node.Provisioning, Keep: config.Node.KeepRegions}, config.Opensim.ExternalAddress, rStats, rEvents, n.logger)
 	err = rMgr.Initialize()
 	if err != nil {
 		n.logger.Error("Error instantiating RegionManager: ", err.Error())
 		return
 	}
+	//regions kept from a previous run are reported to MGM, which reconciles them against its assignments
+	regions, err := rMgr.Restore()
+	if err != nil {
+		n.logger.Error("Error restoring regions: ", err.Error())
+		return
+	}
 
 	for {
 		n.logger.Info("Connecting to MGM")
@@ -94,6 +200,15 @@ func main() {
 		}
 		n.logger.Info("MGM Node connected to MGM")
 
+		err = n.enroll(conn, config.Node.Secret)
+		if err != nil {
+			n.logger.Error("Enrollment with MGM failed: %v", err.Error())
+			conn.Close()
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		n.logger.Info("MGM Node enrolled")
+
 		receiveChan := make(chan host.Message, 32)
 		nc := host.Comms{
 			Connection: conn,
@@ -112,25 +227,43 @@ func main() {
 			}
 		}()
 
-		if !connectedAtLeastOnce {
-			//new connection
-			//update registration
-			reg := host.Registration{}
-			reg.ExternalAddress = config.Opensim.ExternalAddress
-			reg.Name = hostname
-			reg.Slots = int(config.Opensim.MaxRegionPort-config.Opensim.MinRegionPort) + 1
-			conn.WriteJSON(host.Message{MessageType: "Register", Register: reg})
-			//check for region changes since startup
-			conn.WriteJSON(host.Message{MessageType: "GetRegions"})
-
-			connectedAtLeastOnce = true
+		//every connection must register before MGM will talk to us
+		reg := host.NewRegistration()
+		reg.ExternalAddress = config.Opensim.ExternalAddress
+		reg.Name = hostname
+		reg.Slots = int(config.Opensim.MaxRegionPort-config.Opensim.MinRegionPort) + 1
+		reg.MinRegionPort = int(config.Opensim.MinRegionPort)
+		reg.MaxRegionPort = int(config.Opensim.MaxRegionPort)
+		reg.MinConsolePort = int(config.Opensim.MinConsolePort)
+		reg.MaxConsolePort = int(config.Opensim.MaxConsolePort)
+		reg.Labels = config.Node.Label
+		for name := range builds.Dirs {
+			reg.Builds = append(reg.Builds, name)
+		}
+		sort.Strings(reg.Builds)
+		conn.WriteJSON(host.Message{MessageType: "Register", Register: reg})
+
+		//replies produced outside of this loop, such as stop progress, are written here
+		outbound := make(chan host.Message, 64)
+		//closed with the connection, replies still in flight then have nobody to go to
+		connDone := make(chan bool)
+		reply := func(m host.Message) {
+			select {
+			case outbound <- m:
+			case <-connDone:
+			}
 		}
+		//what the MGM on the other end of this connection supports
+		mgmReg := host.Registration{}
 
 	ProcessingPackets:
 		for {
 			select {
+			case m := <-outbound:
+				conn.WriteJSON(m)
 			case <-nc.Closing:
 				n.logger.Error("Disconnected from MGM")
+				close(connDone)
 				time.Sleep(10 * time.Second)
 				break ProcessingPackets
 			case stats := <-hStats:
@@ -143,12 +276,48 @@ func main() {
 				nmsg.MessageType = "RegionStats"
 				nmsg.RStats = stats
 				conn.WriteJSON(nmsg)
+			case ev := <-rEvents:
+				if !mgmReg.HasCapability(host.CapRegionEvents) {
+					n.logger.Info("MGM does not accept region events, dropping %v event for %v", ev.Type, ev.UUID)
+					continue
+				}
+				conn.WriteJSON(host.Message{MessageType: "RegionEvent", Event: ev})
 			case msg := <-receiveChan:
 				switch msg.MessageType {
+				case "RegisterAccepted":
+					version, err := host.Negotiate(msg.Register)
+					if err != nil {
+						n.logger.Error("MGM accepted registration, but is incompatible: %v", err.Error())
+						conn.Close()
+						continue
+					}
+					n.logger.Info("Registered with MGM using protocol version %v", version)
+					mgmReg = msg.Register
+					if !msg.Register.HasCapability(host.CapRegionInventory) {
+						//older MGM, it can only push its region list at us
+						conn.WriteJSON(host.Message{MessageType: "GetRegions"})
+						continue
+					}
+					//report what we hold, so MGM can reconcile against its assignments
+					var held []uuid.UUID
+					inventory := []mgm.RegionStat{}
+					for id, r := range regions {
+						held = append(held, id)
+						inventory = append(inventory, mgm.RegionStat{UUID: id, Running: r.IsRunning()})
+					}
+					err = rMgr.PurgeOrphans(held)
+					if err != nil {
+						n.logger.Error("Error purging orphaned regions: %v", err.Error())
+					}
+					conn.WriteJSON(host.Message{MessageType: "RegionInventory", Inventory: inventory})
+				case "RegisterRejected":
+					n.logger.Error("MGM rejected registration: %v", msg.Message)
+					conn.Close()
 				case "AddRegion":
 					r := msg.Region
 					n.logger.Info("AddRegion: %v", r.UUID.String())
 					m := host.Message{}
+					m.ID = msg.ID
 
 					_, ok := regions[r.UUID]
 					if ok {
@@ -157,13 +326,13 @@ func main() {
 						m.Message = "Region added"
 					} else {
 						//new-to-us region
-						reg, err := rMgr.AddRegion(r.UUID)
-						regions[r.UUID] = reg
+						reg, err := rMgr.AddRegion(r.UUID, r.Version)
 						if err != nil {
 							n.logger.Error("Error adding region: ", err.Error())
 							m.MessageType = "Failure"
 							m.Message = err.Error()
 						} else {
+							regions[r.UUID] = reg
 							m.MessageType = "Success"
 							m.Message = "Region added"
 						}
@@ -174,9 +343,11 @@ func main() {
 					r := msg.Region
 					n.logger.Info("RemoveRegion: %v", r.UUID.String())
 					m := host.Message{}
+					m.ID = msg.ID
 
-					if _, ok := regions[r.UUID]; ok {
-
+					if reg, ok := regions[r.UUID]; ok {
+						//a region cannot be removed out from under its process, nor restarted after it
+						reg.Close()
 						err := rMgr.RemoveRegion(r.UUID)
 						if err != nil {
 							m.MessageType = "Failure"
@@ -190,7 +361,11 @@ func main() {
 						conn.WriteJSON(m)
 						n.logger.Info("RemoveRegion: %v Complete", r.UUID.String())
 					} else {
-						n.logger.Info("RemoveRegion: %v failed, not present", r.UUID.String())
+						//nothing to remove, the region is already gone
+						n.logger.Info("RemoveRegion: %v not present", r.UUID.String())
+						m.MessageType = "Success"
+						m.Message = "Region not present"
+						conn.WriteJSON(m)
 					}
 				case "StartRegion":
 					reg := msg.Region
@@ -216,10 +391,32 @@ func main() {
 							conn.WriteJSON(m)
 							continue
 						}
-						r.Start()
+						timeout := startupTimeout
+						if msg.Startup > 0 {
+							timeout = msg.Startup
+						}
+						rt, err := remote.ResolveRuntime(runtime, msg.Runtime)
+						if err != nil {
+							m.MessageType = "Failure"
+							m.Message = err.Error()
+							conn.WriteJSON(m)
+							continue
+						}
+						r.Start(remote.StartOptions{
+							Restart:        msg.Restart,
+							Runtime:        rt,
+							Limits:         remote.ResolveLimits(limits, msg.Limits),
+							CgroupRoot:     config.Limits.CgroupRoot,
+							HTTPPort:       reg.HTTPPort,
+							StartupTimeout: timeout,
+							ReadyMarkers:   readyMarkers,
+						})
 						m.MessageType = "Success"
 						m.Message = "Region started"
 						conn.WriteJSON(m)
+					} else {
+						n.logger.Info("StartRegion: %v failed, not present", reg.UUID.String())
+						conn.WriteJSON(host.Message{ID: msg.ID, MessageType: "Failure", Message: "Region is not present on this host"})
 					}
 				case "KillRegion":
 					reg := msg.Region
@@ -231,13 +428,81 @@ func main() {
 						m.MessageType = "Success"
 						m.Message = "Region killed"
 						conn.WriteJSON(m)
+					} else {
+						n.logger.Info("KillRegion: %v failed, not present", reg.UUID.String())
+						conn.WriteJSON(host.Message{ID: msg.ID, MessageType: "Failure", Message: "Region is not present on this host"})
+					}
+				case "StopRegion":
+					reg := msg.Region
+					if r, ok := regions[reg.UUID]; ok {
+						go func(id uint, progress <-chan remote.StopProgress) {
+							for p := range progress {
+								switch {
+								case p.Err != nil:
+									reply(host.Message{ID: id, MessageType: "Failure", Message: p.Err.Error()})
+								case p.Done:
+									reply(host.Message{ID: id, MessageType: "Success", Message: p.Message})
+								default:
+									reply(host.Message{ID: id, MessageType: "Progress", Message: p.Message})
+								}
+							}
+						}(msg.ID, r.Stop(reg, msg.Message, grace))
+					} else {
+						n.logger.Info("StopRegion: %v failed, not present", reg.UUID.String())
+						conn.WriteJSON(host.Message{ID: msg.ID, MessageType: "Failure", Message: "Region is not present on this host"})
+					}
+				case "GetRegionLog":
+					reg := msg.Region
+					r, ok := regions[reg.UUID]
+					if !ok {
+						conn.WriteJSON(host.Message{ID: msg.ID, MessageType: "Failure", Message: "Region is not present on this host"})
+						continue
 					}
+					count := msg.Lines
+					if count <= 0 {
+						count = 100
+					}
+					lines, err := r.Output(count)
+					if err != nil {
+						conn.WriteJSON(host.Message{ID: msg.ID, MessageType: "Failure", Message: err.Error()})
+						continue
+					}
+					conn.WriteJSON(host.Message{ID: msg.ID, MessageType: "Success", Output: lines})
+				case "Console":
+					reg := msg.Region
+					if _, ok := regions[reg.UUID]; !ok {
+						conn.WriteJSON(host.Message{ID: msg.ID, MessageType: "Failure", Message: "Region is not present on this host"})
+						continue
+					}
+					//console reads are held open by the region, so never block the connection on them
+					go func(id uint, reg mgm.Region, call mgm.ConsoleCall) {
+						result, err := remote.RelayConsole(reg, call)
+						if err != nil {
+							reply(host.Message{ID: id, MessageType: "Failure", Message: err.Error()})
+							return
+						}
+						reply(host.Message{ID: id, MessageType: "Success", Console: result})
+					}(msg.ID, reg, msg.Console)
 				case "RemoveHost":
 					n.logger.Info("Received RemoveHost command from MGM, terminating")
 					//terminate connection to MGM
 					conn.Close()
 					//kill any running regions
-					//regions are child processes, and are killed as long as we dont crash out
+					//regions run in their own sessions to survive node restarts, so they must be killed explicitly
+					for _, r := range regions {
+						if r.IsRunning() {
+							r.Kill()
+						}
+					}
+					for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(250 * time.Millisecond) {
+						running := false
+						for _, r := range regions {
+							running = running || r.IsRunning()
+						}
+						if !running {
+							break
+						}
+					}
 					//exit
 					os.Exit(0)
 				default:
@@ -249,17 +514,47 @@ func main() {
 	}
 }
 
-func (node mgmNode) collectHostStatistics(out chan mgm.HostStat) {
+// enroll answers the MGM challenge, proving this node holds the secret issued for its host
+func (node mgmNode) enroll(conn *websocket.Conn, secret string) error {
+	msg := host.Message{}
+	err := conn.ReadJSON(&msg)
+	if err != nil {
+		return err
+	}
+	if msg.MessageType != "AuthChallenge" {
+		return fmt.Errorf("Expected AuthChallenge, received %v", msg.MessageType)
+	}
+
+	err = conn.WriteJSON(host.Message{MessageType: "AuthResponse", Message: host.SignChallenge(secret, msg.Message)})
+	if err != nil {
+		return err
+	}
+
+	//MGM drops the connection if we are not accepted
+	err = conn.ReadJSON(&msg)
+	if err != nil {
+		return errors.New("Connection closed by MGM, confirm the node secret is current")
+	}
+	if msg.MessageType != "AuthAccepted" {
+		return fmt.Errorf("Expected AuthAccepted, received %v", msg.MessageType)
+	}
+	return nil
+}
+
+func (node mgmNode) collectHostStatistics(out chan mgm.HostStat, regionDir string) {
+	device := node.regionDevice(regionDir)
 	for {
 		//start calculating network sent
 		fInet, err := psnet.NetIOCounters(false)
 		if err != nil {
 			node.logger.Error("Error reading networking", err)
 		}
+		fDisk, _ := psdisk.DiskIOCounters()
 
 		s := mgm.HostStat{}
 		s.Running = true
-		c, err := pscpu.CPUPercent(time.Second, true)
+		//sampling cpu paces the reports, MGM expects one every host.HostStatInterval
+		c, err := pscpu.CPUPercent(host.HostStatInterval, true)
 		if err != nil {
 			node.logger.Error("Error readin CPU: ", err)
 		}
@@ -280,19 +575,67 @@ func (node mgmNode) collectHostStatistics(out chan mgm.HostStat) {
 		s.NetSent = (lInet[0].BytesSent - fInet[0].BytesSent)
 		s.NetRecv = (lInet[0].BytesRecv - fInet[0].BytesRecv)
 
+		d, err := psdisk.DiskUsage(regionDir)
+		if err != nil {
+			node.logger.Error("Error reading disk usage", err)
+		} else {
+			s.DiskTotal = d.Total / 1000
+			s.DiskUsed = d.Used / 1000
+			s.DiskPercent = d.UsedPercent
+		}
+		lDisk, err := psdisk.DiskIOCounters()
+		if err == nil {
+			//io over the cpu sampling second, like network
+			s.DiskRead = lDisk[device].ReadBytes - fDisk[device].ReadBytes
+			s.DiskWrite = lDisk[device].WriteBytes - fDisk[device].WriteBytes
+		}
+
 		out <- s
 	}
 }
 
-func validateConfig(config nodeConfig) error {
-	exists, err := fileExists(config.Node.OpensimBinDir)
+// regionDevice finds the block device holding the region directory, as named by the io counters
+func (node mgmNode) regionDevice(regionDir string) string {
+	parts, err := psdisk.DiskPartitions(false)
 	if err != nil {
-		return err
+		node.logger.Error("Error reading partitions", err)
+		return ""
 	}
-	if !exists {
-		return errors.New("Opensim Bin Dir does not exist")
+	dir, _ := filepath.Abs(regionDir)
+	return filepath.Base(holdingPartition(dir, parts).Device)
+}
+
+// holdingPartition finds the partition a path is on, the one with the deepest mountpoint containing it
+func holdingPartition(path string, parts []psdisk.DiskPartitionStat) psdisk.DiskPartitionStat {
+	best := psdisk.DiskPartitionStat{}
+	for _, p := range parts {
+		//a mountpoint only contains paths below it, /srv does not hold /srv2
+		mp := strings.TrimSuffix(p.Mountpoint, "/")
+		if path != mp && !strings.HasPrefix(path, mp+"/") {
+			continue
+		}
+		if best.Mountpoint == "" || len(mp) > len(strings.TrimSuffix(best.Mountpoint, "/")) {
+			best = p
+		}
+	}
+	return best
+}
+
+func validateConfig(config nodeConfig) error {
+	builds := configuredBuilds(config)
+	if _, ok := builds.Dirs[builds.Default]; !ok {
+		return fmt.Errorf("Default build %v is not configured", builds.Default)
 	}
-	exists, err = fileExists(config.Node.RegionDir)
+	for name, dir := range builds.Dirs {
+		exists, err := fileExists(dir)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("Bin Dir for build %v does not exist", name)
+		}
+	}
+	exists, err := fileExists(config.Node.RegionDir)
 	if err != nil {
 		return err
 	}
@@ -303,6 +646,14 @@ func validateConfig(config nodeConfig) error {
 	if config.Node.MGMAddress == "" {
 		return errors.New("MGM address is required")
 	}
+	if config.Node.Secret == "" {
+		return errors.New("Node secret is required")
+	}
+	switch config.Node.Provisioning {
+	case "", remote.ProvisionCopy, remote.ProvisionHardlink, remote.ProvisionSymlink:
+	default:
+		return errors.New("Provisioning must be one of copy, hardlink or symlink")
+	}
 	if config.Opensim.ExternalAddress == "" {
 		return errors.New("External address is required")
 	}
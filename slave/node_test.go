@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	psdisk "github.com/shirou/gopsutil/disk"
+)
+
+func TestHoldingPartition(t *testing.T) {
+	parts := []psdisk.DiskPartitionStat{
+		{Device: "/dev/sda1", Mountpoint: "/"},
+		{Device: "/dev/sdb1", Mountpoint: "/srv"},
+		{Device: "/dev/sdc1", Mountpoint: "/srv/regions"},
+		{Device: "/dev/sdd1", Mountpoint: "/data/"},
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/srv/regions/a", "/dev/sdc1"},
+		{"/srv/regions", "/dev/sdc1"},
+		{"/srv/regions2", "/dev/sdb1"},
+		{"/srv2/regions", "/dev/sda1"},
+		{"/srv", "/dev/sdb1"},
+		{"/data/regions", "/dev/sdd1"},
+		{"/database", "/dev/sda1"},
+		{"/opt", "/dev/sda1"},
+	}
+	for _, tt := range tests {
+		if got := holdingPartition(tt.path, parts).Device; got != tt.want {
+			t.Errorf("holdingPartition(%v) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+	if got := holdingPartition("/srv", nil).Device; got != "" {
+		t.Errorf("without partitions got %v", got)
+	}
+}